@@ -1,54 +1,91 @@
 package main
 
+import "cjting.me/lox/scanner"
+
 type Callable interface {
-	Call(env *Env, arguments []Val) Val
+	// paren is the closing paren of the call, used to report runtime errors
+	Call(env *Env, paren *scanner.Token, arguments []Val) Val
 	Arity() int
 }
 
 type Function struct {
 	arity    int
-	function func(*Env, []Val) Val
+	function func(*Env, *scanner.Token, []Val) Val
 }
 
-func NewFunction(arity int, function func(*Env, []Val) Val) *Function {
+func NewFunction(arity int, function func(*Env, *scanner.Token, []Val) Val) *Function {
 	return &Function{arity, function}
 }
 
+func (f *Function) String() string {
+	return "<native fn>"
+}
+
 func (f *Function) Arity() int {
 	return f.arity
 }
 
-func (f *Function) Call(env *Env, arguments []Val) Val {
-	return f.function(env, arguments)
+func (f *Function) Call(env *Env, paren *scanner.Token, arguments []Val) Val {
+	return f.function(env, paren, arguments)
 }
 
 /*----------  Lox Function  ----------*/
 
-func (s *StmtFuncDecl) Arity() int {
-	return len(s.parameters)
+// LoxFunction is the runtime value of a function declaration,
+// every time the declaration runs, a new LoxFunction is created
+type LoxFunction struct {
+	declaration   *StmtFuncDecl
+	closure       *Env
+	isInitializer bool
+}
+
+func NewLoxFunction(declaration *StmtFuncDecl, closure *Env, isInitializer bool) *LoxFunction {
+	return &LoxFunction{declaration, closure, isInitializer}
 }
 
-func (s *StmtFuncDecl) Call(_env *Env, arguments []Val) (result Val) {
-	newEnv := NewEnv(s.closure)
+func (f *LoxFunction) String() string {
+	if f.declaration.name == nil {
+		return "<fn anonymous>"
+	}
+	return "<fn " + f.declaration.name.Lexeme + ">"
+}
+
+func (f *LoxFunction) Arity() int {
+	return len(f.declaration.parameters)
+}
+
+func (f *LoxFunction) Call(_env *Env, paren *scanner.Token, arguments []Val) Val {
+	lox := f.closure.global().module.lox
+	if lox.callDepth >= lox.maxCallDepth {
+		panic(NewRuntimeError(paren, "stack overflow"))
+	}
+	lox.callDepth++
+	// errors unwinding through the call still leave it
+	defer func() { lox.callDepth-- }()
+
+	newEnv := NewEnv(f.closure)
 	for i, arg := range arguments {
-		name := s.parameters[i].Lexeme
+		name := f.declaration.parameters[i].Lexeme
 		newEnv.Define(name, arg)
 	}
 
-	// handle function return
-	defer func() {
-		if err := recover(); err != nil {
-			if fr, ok := err.(*FunctionReturn); ok {
-				result = fr.value
-			} else {
-				panic(err)
-			}
-		}
-	}()
-
-	for _, stmt := range s.body {
-		stmt.Run(newEnv)
+	completion := runStmts(f.declaration.body, newEnv)
+
+	// `return;` in initializer still returns the instance
+	if f.isInitializer {
+		return f.closure.slots[0]
+	}
+
+	if completion.typ == CompletionReturn {
+		return completion.value
 	}
 
 	return nil
 }
+
+// return a copy of the method whose closure has `this` bound to instance
+func (f *LoxFunction) Bind(instance *LoxInstance) *LoxFunction {
+	env := NewEnv(f.closure)
+	env.Define("this", instance)
+	return NewLoxFunction(f.declaration, env, f.isInitializer)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+type OpCode byte
+
+// operands follow the opcode, u8 is one byte, u16 is two bytes in big endian
+const (
+	OP_CONSTANT      OpCode = iota // u16 constant
+	OP_NIL                         //
+	OP_TRUE                        //
+	OP_FALSE                       //
+	OP_POP                         //
+	OP_DUP                         //
+	OP_GET_LOCAL                   // u8 slot
+	OP_SET_LOCAL                   // u8 slot
+	OP_GET_GLOBAL                  // u16 name
+	OP_DEFINE_GLOBAL               // u16 name
+	OP_SET_GLOBAL                  // u16 name
+	OP_GET_UPVALUE                 // u8 index
+	OP_SET_UPVALUE                 // u8 index
+	OP_GET_PROPERTY                // u16 name
+	OP_SET_PROPERTY                // u16 name
+	OP_GET_SUPER                   // u16 name
+	OP_EQUAL                       //
+	OP_NOT_EQUAL                   //
+	OP_GREATER                     //
+	OP_GREATER_EQUAL               //
+	OP_LESS                        //
+	OP_LESS_EQUAL                  //
+	OP_ADD                         //
+	OP_SUBTRACT                    //
+	OP_MULTIPLY                    //
+	OP_DIVIDE                      //
+	OP_INT_DIVIDE                  //
+	OP_MODULO                      //
+	OP_POWER                       //
+	OP_BIT_AND                     //
+	OP_BIT_OR                      //
+	OP_BIT_XOR                     //
+	OP_SHIFT_LEFT                  //
+	OP_SHIFT_RIGHT                 //
+	OP_NOT                         //
+	OP_NEGATE                      //
+	OP_BIT_NOT                     //
+	OP_INCREMENT                   //
+	OP_DECREMENT                   //
+	OP_PRINT                       //
+	OP_JUMP                        // u16 forward offset
+	OP_JUMP_IF_FALSE               // u16 forward offset, condition is not popped
+	OP_LOOP                        // u16 backward offset
+	OP_CALL                        // u8 argument count
+	OP_CLOSURE                     // u16 function, then (u8 isLocal, u8 index) per upvalue
+	OP_CLOSE_UPVALUE               //
+	OP_RETURN                      //
+	OP_CLASS                       // u16 name
+	OP_INHERIT                     //
+	OP_METHOD                      // u16 name
+	OP_LIST                        // u16 element count
+	OP_MAP                         // u16 entry count
+	OP_INDEX                       //
+	OP_INDEX_SET                   //
+	OP_SLICE                       //
+	OP_INTERPOLATE                 // u16 part count
+	OP_ITERATOR                    //
+	OP_FOR_NEXT                    // u16 forward offset to jump when iterator is exhausted
+	OP_THROW                       //
+	OP_TRY                         // u16 forward offset of the catch handler
+	OP_TRY_FINALLY                 // u16 forward offset of the finally handler
+	OP_END_TRY                     //
+	OP_IMPORT                      // u16 path
+)
+
+var opNames = [...]string{
+	OP_CONSTANT:      "OP_CONSTANT",
+	OP_NIL:           "OP_NIL",
+	OP_TRUE:          "OP_TRUE",
+	OP_FALSE:         "OP_FALSE",
+	OP_POP:           "OP_POP",
+	OP_DUP:           "OP_DUP",
+	OP_GET_LOCAL:     "OP_GET_LOCAL",
+	OP_SET_LOCAL:     "OP_SET_LOCAL",
+	OP_GET_GLOBAL:    "OP_GET_GLOBAL",
+	OP_DEFINE_GLOBAL: "OP_DEFINE_GLOBAL",
+	OP_SET_GLOBAL:    "OP_SET_GLOBAL",
+	OP_GET_UPVALUE:   "OP_GET_UPVALUE",
+	OP_SET_UPVALUE:   "OP_SET_UPVALUE",
+	OP_GET_PROPERTY:  "OP_GET_PROPERTY",
+	OP_SET_PROPERTY:  "OP_SET_PROPERTY",
+	OP_GET_SUPER:     "OP_GET_SUPER",
+	OP_EQUAL:         "OP_EQUAL",
+	OP_NOT_EQUAL:     "OP_NOT_EQUAL",
+	OP_GREATER:       "OP_GREATER",
+	OP_GREATER_EQUAL: "OP_GREATER_EQUAL",
+	OP_LESS:          "OP_LESS",
+	OP_LESS_EQUAL:    "OP_LESS_EQUAL",
+	OP_ADD:           "OP_ADD",
+	OP_SUBTRACT:      "OP_SUBTRACT",
+	OP_MULTIPLY:      "OP_MULTIPLY",
+	OP_DIVIDE:        "OP_DIVIDE",
+	OP_INT_DIVIDE:    "OP_INT_DIVIDE",
+	OP_MODULO:        "OP_MODULO",
+	OP_POWER:         "OP_POWER",
+	OP_BIT_AND:       "OP_BIT_AND",
+	OP_BIT_OR:        "OP_BIT_OR",
+	OP_BIT_XOR:       "OP_BIT_XOR",
+	OP_SHIFT_LEFT:    "OP_SHIFT_LEFT",
+	OP_SHIFT_RIGHT:   "OP_SHIFT_RIGHT",
+	OP_NOT:           "OP_NOT",
+	OP_NEGATE:        "OP_NEGATE",
+	OP_BIT_NOT:       "OP_BIT_NOT",
+	OP_INCREMENT:     "OP_INCREMENT",
+	OP_DECREMENT:     "OP_DECREMENT",
+	OP_PRINT:         "OP_PRINT",
+	OP_JUMP:          "OP_JUMP",
+	OP_JUMP_IF_FALSE: "OP_JUMP_IF_FALSE",
+	OP_LOOP:          "OP_LOOP",
+	OP_CALL:          "OP_CALL",
+	OP_CLOSURE:       "OP_CLOSURE",
+	OP_CLOSE_UPVALUE: "OP_CLOSE_UPVALUE",
+	OP_RETURN:        "OP_RETURN",
+	OP_CLASS:         "OP_CLASS",
+	OP_INHERIT:       "OP_INHERIT",
+	OP_METHOD:        "OP_METHOD",
+	OP_LIST:          "OP_LIST",
+	OP_MAP:           "OP_MAP",
+	OP_INDEX:         "OP_INDEX",
+	OP_INDEX_SET:     "OP_INDEX_SET",
+	OP_SLICE:         "OP_SLICE",
+	OP_INTERPOLATE:   "OP_INTERPOLATE",
+	OP_ITERATOR:      "OP_ITERATOR",
+	OP_FOR_NEXT:      "OP_FOR_NEXT",
+	OP_THROW:         "OP_THROW",
+	OP_TRY:           "OP_TRY",
+	OP_TRY_FINALLY:   "OP_TRY_FINALLY",
+	OP_END_TRY:       "OP_END_TRY",
+	OP_IMPORT:        "OP_IMPORT",
+}
+
+func (op OpCode) String() string {
+	if int(op) < len(opNames) && opNames[op] != "" {
+		return opNames[op]
+	}
+	return fmt.Sprintf("OP_UNKNOWN(%d)", op)
+}
+
+/*----------  Chunk  ----------*/
+
+// Chunk is the compiled code of one function
+type Chunk struct {
+	code      []byte
+	constants []Val
+	// run-length encoded, line of code[i] is the line of the last
+	// entry whose offset <= i
+	lines []lineStart
+}
+
+type lineStart struct {
+	offset int
+	line   int
+}
+
+func NewChunk() *Chunk {
+	return &Chunk{}
+}
+
+func (c *Chunk) write(b byte, line int) {
+	if n := len(c.lines); n == 0 || c.lines[n-1].line != line {
+		c.lines = append(c.lines, lineStart{len(c.code), line})
+	}
+	c.code = append(c.code, b)
+}
+
+// return index of the new constant
+func (c *Chunk) addConstant(val Val) int {
+	c.constants = append(c.constants, val)
+	return len(c.constants) - 1
+}
+
+// line of the instruction at offset
+func (c *Chunk) line(offset int) int {
+	i := sort.Search(len(c.lines), func(i int) bool {
+		return c.lines[i].offset > offset
+	})
+	if i == 0 {
+		return 0
+	}
+	return c.lines[i-1].line
+}
+
+func (c *Chunk) readShort(offset int) int {
+	return int(c.code[offset])<<8 | int(c.code[offset+1])
+}
+
+/*----------  Disassembler  ----------*/
+
+// one instruction per line, used for debugging and tests
+func (c *Chunk) Disassemble(name string) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "== %s ==\n", name)
+	for offset := 0; offset < len(c.code); {
+		offset = c.disassembleInstruction(buf, offset)
+	}
+	return buf.String()
+}
+
+func (c *Chunk) disassembleInstruction(buf *bytes.Buffer, offset int) int {
+	fmt.Fprintf(buf, "%04d ", offset)
+	if offset > 0 && c.line(offset) == c.line(offset-1) {
+		buf.WriteString("   | ")
+	} else {
+		fmt.Fprintf(buf, "%4d ", c.line(offset))
+	}
+
+	op := OpCode(c.code[offset])
+	switch op {
+	case OP_CONSTANT, OP_GET_GLOBAL, OP_DEFINE_GLOBAL, OP_SET_GLOBAL,
+		OP_GET_PROPERTY, OP_SET_PROPERTY, OP_GET_SUPER,
+		OP_CLASS, OP_METHOD, OP_IMPORT:
+		index := c.readShort(offset + 1)
+		fmt.Fprintf(buf, "%-16s %4d %s\n", op, index, reprValue(c.constants[index]))
+		return offset + 3
+	case OP_GET_LOCAL, OP_SET_LOCAL, OP_GET_UPVALUE, OP_SET_UPVALUE, OP_CALL:
+		fmt.Fprintf(buf, "%-16s %4d\n", op, c.code[offset+1])
+		return offset + 2
+	case OP_LIST, OP_MAP, OP_INTERPOLATE:
+		fmt.Fprintf(buf, "%-16s %4d\n", op, c.readShort(offset+1))
+		return offset + 3
+	case OP_JUMP, OP_JUMP_IF_FALSE, OP_FOR_NEXT, OP_TRY, OP_TRY_FINALLY:
+		jump := c.readShort(offset + 1)
+		fmt.Fprintf(buf, "%-16s %4d -> %d\n", op, offset, offset+3+jump)
+		return offset + 3
+	case OP_LOOP:
+		jump := c.readShort(offset + 1)
+		fmt.Fprintf(buf, "%-16s %4d -> %d\n", op, offset, offset+3-jump)
+		return offset + 3
+	case OP_CLOSURE:
+		index := c.readShort(offset + 1)
+		function := c.constants[index].(*VMFunction)
+		fmt.Fprintf(buf, "%-16s %4d %s\n", op, index, function)
+		offset += 3
+		for i := 0; i < function.upvalueCount; i++ {
+			kind := "upvalue"
+			if c.code[offset] == 1 {
+				kind = "local"
+			}
+			fmt.Fprintf(buf, "%04d    |                     %s %d\n", offset, kind, c.code[offset+1])
+			offset += 2
+		}
+		return offset
+	}
+
+	fmt.Fprintf(buf, "%s\n", op)
+	return offset + 1
+}
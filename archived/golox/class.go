@@ -0,0 +1,78 @@
+package main
+
+import "cjting.me/lox/scanner"
+
+/*----------  Lox Class  ----------*/
+
+type LoxClass struct {
+	name       string
+	superclass *LoxClass
+	methods    map[string]*LoxFunction
+}
+
+func NewLoxClass(name string, superclass *LoxClass, methods map[string]*LoxFunction) *LoxClass {
+	return &LoxClass{name, superclass, methods}
+}
+
+func (c *LoxClass) String() string {
+	return c.name
+}
+
+// look up method in class and its superclasses
+func (c *LoxClass) FindMethod(name string) *LoxFunction {
+	if method, ok := c.methods[name]; ok {
+		return method
+	}
+	if c.superclass != nil {
+		return c.superclass.FindMethod(name)
+	}
+	return nil
+}
+
+func (c *LoxClass) Arity() int {
+	if init := c.FindMethod("init"); init != nil {
+		return init.Arity()
+	}
+	return 0
+}
+
+// calling a class produces a new instance
+func (c *LoxClass) Call(env *Env, paren *scanner.Token, arguments []Val) Val {
+	instance := NewLoxInstance(c)
+	if init := c.FindMethod("init"); init != nil {
+		init.Bind(instance).Call(env, paren, arguments)
+	}
+	return instance
+}
+
+/*----------  Lox Instance  ----------*/
+
+type LoxInstance struct {
+	class  *LoxClass
+	fields map[string]Val
+}
+
+func NewLoxInstance(class *LoxClass) *LoxInstance {
+	return &LoxInstance{class, map[string]Val{}}
+}
+
+func (i *LoxInstance) String() string {
+	return i.class.name + " instance"
+}
+
+// fields shadow methods
+func (i *LoxInstance) Get(name *scanner.Token) Val {
+	if val, ok := i.fields[name.Lexeme]; ok {
+		return val
+	}
+
+	if method := i.class.FindMethod(name.Lexeme); method != nil {
+		return method.Bind(i)
+	}
+
+	panic(NewRuntimeError(name, sprintf("undefined property '%s'", name.Lexeme)))
+}
+
+func (i *LoxInstance) Set(name *scanner.Token, val Val) {
+	i.fields[name.Lexeme] = val
+}
@@ -0,0 +1,912 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"cjting.me/lox/scanner"
+)
+
+// Compiler turns the AST of a module into bytecode for the VM, like
+// Resolver it's a type switch over statements and expressions. It runs
+// after the resolver, so the program is known to be semantically valid,
+// only limits of the bytecode format are reported as errors
+type Compiler struct {
+	enclosing *Compiler
+	function  *VMFunction
+	typ       FunctionType
+	// slot 0 is the callee, or `this` in methods
+	locals     []local
+	upvalues   []upvalueRef
+	scopeDepth int
+	// constant index of identifiers
+	names map[string]int
+	// loops and switches we are in, innermost last
+	breakables []*breakable
+	// try statements we are in, innermost last
+	tries []*tryBlock
+	// line of the instructions being emitted
+	line   int
+	errors *CompileErrors
+}
+
+type local struct {
+	name     string
+	depth    int
+	captured bool
+}
+
+type upvalueRef struct {
+	index   int
+	isLocal bool
+}
+
+// target of break, and of continue if it's a loop
+type breakable struct {
+	isLoop bool
+	// locals which stay on stack after jumping out
+	localCount int
+	// tries which are not left by jumping out
+	tryCount  int
+	breaks    []int
+	continues []int
+}
+
+// try statement with a handler installed
+type tryBlock struct {
+	localCount int
+	// nil for try without finally, leaving it only removes the handler
+	finally *StmtBlock
+	// hidden local which holds the return value while finally runs
+	returnSlot int
+}
+
+type CompileError struct {
+	line int
+	msg  string
+}
+
+func (ce *CompileError) Error() string {
+	return fmt.Sprintf("line %d, %s", ce.line, ce.msg)
+}
+
+// all errors found in one pass
+type CompileErrors []*CompileError
+
+func (errs CompileErrors) Error() string {
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+const (
+	maxLocals    = 256
+	maxUpvalues  = 256
+	maxConstants = 1 << 16
+	maxJump      = 1<<16 - 1
+)
+
+func NewCompiler(enclosing *Compiler, function *VMFunction, typ FunctionType) *Compiler {
+	c := &Compiler{
+		enclosing: enclosing,
+		function:  function,
+		typ:       typ,
+		names:     map[string]int{},
+	}
+	if enclosing != nil {
+		c.errors = enclosing.errors
+		c.line = enclosing.line
+	} else {
+		c.errors = &CompileErrors{}
+	}
+
+	slot0 := ""
+	if typ == FunctionTypeMethod || typ == FunctionTypeInitializer {
+		slot0 = "this"
+	}
+	c.locals = append(c.locals, local{slot0, 0, false})
+	return c
+}
+
+// compile top-level code of module to a function
+func Compile(module *LoxModule, program []Stmt) (*VMFunction, error) {
+	c := NewCompiler(nil, NewVMFunction("", module), FunctionTypeNone)
+	c.compileStmts(program)
+	c.emitReturn()
+	return c.function, c.err()
+}
+
+// compile a function which returns the value of expr, used by REPL
+func CompileExpression(module *LoxModule, expr Expr) (*VMFunction, error) {
+	c := NewCompiler(nil, NewVMFunction("", module), FunctionTypeNone)
+	c.compileExpr(expr)
+	c.emitOp(OP_RETURN)
+	return c.function, c.err()
+}
+
+func (c *Compiler) err() error {
+	if len(*c.errors) > 0 {
+		return *c.errors
+	}
+	return nil
+}
+
+/*----------  Stmt  ----------*/
+
+func (c *Compiler) compileStmts(stmts []Stmt) {
+	for _, stmt := range stmts {
+		c.compileStmt(stmt)
+	}
+}
+
+func (c *Compiler) compileStmt(stmt Stmt) {
+	switch s := stmt.(type) {
+	case *StmtExpression:
+		c.compileExpr(s.expr)
+		c.emitOp(OP_POP)
+	case *StmtPrint:
+		c.compileExpr(s.expr)
+		c.emitOp(OP_PRINT)
+	case *StmtVarDecl:
+		c.line = s.name.Line
+		if s.value != nil {
+			c.compileExpr(s.value)
+		} else {
+			c.emitOp(OP_NIL)
+		}
+		c.defineVariable(s.name)
+	case *StmtBlock:
+		c.beginScope()
+		c.compileStmts(s.stmts)
+		c.endScope()
+	case *StmtIf:
+		c.compileExpr(s.condition)
+		elseJump := c.emitJump(OP_JUMP_IF_FALSE)
+		c.emitOp(OP_POP)
+		c.compileStmt(s.trueBranch)
+		endJump := c.emitJump(OP_JUMP)
+		c.patchJump(elseJump)
+		c.emitOp(OP_POP)
+		if s.falseBranch != nil {
+			c.compileStmt(s.falseBranch)
+		}
+		c.patchJump(endJump)
+	case *StmtWhile:
+		c.compileWhile(s)
+	case *StmtForIn:
+		c.compileForIn(s)
+	case *StmtSwitch:
+		c.compileSwitch(s)
+	case *StmtBreak:
+		b := c.breakables[len(c.breakables)-1]
+		c.line = s.keyword.Line
+		c.jumpOut(b)
+		b.breaks = append(b.breaks, c.emitJump(OP_JUMP))
+	case *StmtContinue:
+		var b *breakable
+		for i := len(c.breakables) - 1; i >= 0; i-- {
+			if c.breakables[i].isLoop {
+				b = c.breakables[i]
+				break
+			}
+		}
+		c.line = s.keyword.Line
+		c.jumpOut(b)
+		b.continues = append(b.continues, c.emitJump(OP_JUMP))
+	case *StmtFuncDecl:
+		c.line = s.name.Line
+		// define before compiling the body to support recursion
+		if c.scopeDepth > 0 {
+			c.addLocal(s.name.Lexeme)
+			c.compileFunction(s, FunctionTypeFunction)
+		} else {
+			c.compileFunction(s, FunctionTypeFunction)
+			c.defineVariable(s.name)
+		}
+	case *StmtClassDecl:
+		c.compileClass(s)
+	case *StmtReturn:
+		c.line = s.token.Line
+		if c.typ == FunctionTypeInitializer {
+			c.emitOpByte(OP_GET_LOCAL, 0)
+		} else if s.value != nil {
+			c.compileExpr(s.value)
+		} else {
+			c.emitOp(OP_NIL)
+		}
+		c.returnThroughTries()
+	case *StmtThrow:
+		c.compileExpr(s.value)
+		c.line = s.keyword.Line
+		c.emitOp(OP_THROW)
+	case *StmtTry:
+		c.compileTry(s)
+	case *StmtImport:
+		c.line = s.path.Line
+		c.emitOpShort(OP_IMPORT, c.makeConstant(s.path.Literal))
+		c.defineVariable(s.name)
+	default:
+		panic(sprintf("compiler: unknown stmt %T", stmt))
+	}
+}
+
+func (c *Compiler) compileWhile(s *StmtWhile) {
+	loopStart := len(c.chunk().code)
+	c.compileExpr(s.condition)
+	exitJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+
+	b := c.beginBreakable(true)
+	c.compileStmt(s.body)
+	c.endBreakable()
+
+	// continue runs the increment
+	c.patchJumps(b.continues)
+	if s.increment != nil {
+		c.compileExpr(s.increment)
+		c.emitOp(OP_POP)
+	}
+	c.emitLoop(loopStart)
+
+	c.patchJump(exitJump)
+	c.emitOp(OP_POP)
+	c.patchJumps(b.breaks)
+}
+
+// the iterator is a hidden local, the variable lives in a scope
+// of its own, which is closed at the end of every iteration
+func (c *Compiler) compileForIn(s *StmtForIn) {
+	c.beginScope()
+	c.compileExpr(s.iterable)
+	c.line = s.in.Line
+	c.emitOp(OP_ITERATOR)
+	c.addLocal(" iterator")
+
+	loopStart := len(c.chunk().code)
+	exitJump := c.emitJump(OP_FOR_NEXT)
+
+	b := c.beginBreakable(true)
+	c.beginScope()
+	c.addLocal(s.name.Lexeme)
+	c.compileStmt(s.body)
+	c.endScope()
+	c.endBreakable()
+
+	c.patchJumps(b.continues)
+	c.emitLoop(loopStart)
+
+	c.patchJump(exitJump)
+	c.patchJumps(b.breaks)
+	c.endScope()
+}
+
+// value is kept in a hidden local, each case value is compared with it
+// until one matches
+func (c *Compiler) compileSwitch(s *StmtSwitch) {
+	c.beginScope()
+	c.compileExpr(s.value)
+	c.addLocal(" switch")
+	slot := len(c.locals) - 1
+
+	b := c.beginBreakable(false)
+
+	matches := make([][]int, len(s.cases))
+	for i, sc := range s.cases {
+		for _, value := range sc.values {
+			c.emitOpByte(OP_GET_LOCAL, byte(slot))
+			c.compileExpr(value)
+			c.emitOp(OP_EQUAL)
+			nextJump := c.emitJump(OP_JUMP_IF_FALSE)
+			c.emitOp(OP_POP)
+			matches[i] = append(matches[i], c.emitJump(OP_JUMP))
+			c.patchJump(nextJump)
+			c.emitOp(OP_POP)
+		}
+	}
+	defaultJump := c.emitJump(OP_JUMP)
+
+	var endJumps []int
+	for i, sc := range s.cases {
+		c.patchJumps(matches[i])
+		c.compileStmt(sc.body)
+		endJumps = append(endJumps, c.emitJump(OP_JUMP))
+	}
+
+	c.patchJump(defaultJump)
+	if s.defaultBody != nil {
+		c.compileStmt(s.defaultBody)
+	}
+
+	c.patchJumps(endJumps)
+	c.endBreakable()
+	c.patchJumps(b.breaks)
+	c.endScope()
+}
+
+func (c *Compiler) compileFunction(decl *StmtFuncDecl, typ FunctionType) {
+	name := ""
+	if decl.name != nil {
+		name = decl.name.Lexeme
+	}
+	fc := NewCompiler(c, NewVMFunction(name, c.function.module), typ)
+	fc.function.anonymous = decl.name == nil
+	fc.function.arity = len(decl.parameters)
+
+	fc.beginScope()
+	for _, param := range decl.parameters {
+		fc.addLocal(param.Lexeme)
+	}
+	fc.compileStmts(decl.body)
+	fc.emitReturn()
+
+	fc.function.upvalueCount = len(fc.upvalues)
+	c.emitOpShort(OP_CLOSURE, c.makeConstant(fc.function))
+	for _, upvalue := range fc.upvalues {
+		isLocal := byte(0)
+		if upvalue.isLocal {
+			isLocal = 1
+		}
+		c.emitBytes(isLocal, byte(upvalue.index))
+	}
+}
+
+// like the interpreter, methods of a subclass close over an extra scope
+// which holds `super`
+func (c *Compiler) compileClass(s *StmtClassDecl) {
+	c.line = s.name.Line
+	c.emitOpShort(OP_CLASS, c.identifierConstant(s.name.Lexeme))
+	c.defineVariable(s.name)
+
+	if s.superclass != nil {
+		c.compileExpr(s.superclass)
+		c.beginScope()
+		c.addLocal("super")
+		c.namedVariable(s.name, false)
+		c.line = s.superclass.name.Line
+		c.emitOp(OP_INHERIT)
+	}
+
+	c.namedVariable(s.name, false)
+	for _, method := range s.methods {
+		typ := FunctionTypeMethod
+		if method.name.Lexeme == "init" {
+			typ = FunctionTypeInitializer
+		}
+		c.line = method.name.Line
+		c.compileFunction(method, typ)
+		c.emitOpShort(OP_METHOD, c.identifierConstant(method.name.Lexeme))
+	}
+	c.emitOp(OP_POP)
+
+	if s.superclass != nil {
+		c.endScope()
+	}
+}
+
+// a try with catch installs a handler for body, a try with finally
+// installs another one around body and catch, which runs finally and
+// throws the error again. Leaving try with break, continue or return
+// removes the handlers and runs finally inline
+func (c *Compiler) compileTry(s *StmtTry) {
+	c.beginScope()
+
+	var finallyTry *tryBlock
+	var finallyHandler int
+	if s.finallyBody != nil {
+		returnSlot := -1
+		for _, t := range c.tries {
+			if t.finally != nil {
+				returnSlot = t.returnSlot
+				break
+			}
+		}
+		if returnSlot < 0 {
+			c.emitOp(OP_NIL)
+			c.addLocal(" return")
+			returnSlot = len(c.locals) - 1
+		}
+		finallyTry = &tryBlock{len(c.locals), s.finallyBody, returnSlot}
+		finallyHandler = c.emitJump(OP_TRY_FINALLY)
+		c.tries = append(c.tries, finallyTry)
+	}
+
+	if s.catchName != nil {
+		catchHandler := c.emitJump(OP_TRY)
+		c.tries = append(c.tries, &tryBlock{len(c.locals), nil, -1})
+		c.compileStmt(s.body)
+		c.tries = c.tries[:len(c.tries)-1]
+		c.emitOp(OP_END_TRY)
+		skipCatch := c.emitJump(OP_JUMP)
+
+		// the error value is pushed by VM
+		c.patchJump(catchHandler)
+		c.beginScope()
+		c.addLocal(s.catchName.Lexeme)
+		c.compileStmts(s.catchBody)
+		c.endScope()
+		c.patchJump(skipCatch)
+	} else {
+		c.compileStmt(s.body)
+	}
+
+	if finallyTry != nil {
+		c.tries = c.tries[:len(c.tries)-1]
+		c.emitOp(OP_END_TRY)
+		c.compileStmt(s.finallyBody)
+		skipHandler := c.emitJump(OP_JUMP)
+
+		// the error is pushed by VM as it is, run finally and throw it again
+		c.patchJump(finallyHandler)
+		c.beginScope()
+		c.addLocal(" error")
+		c.compileStmt(s.finallyBody)
+		c.emitOpByte(OP_GET_LOCAL, byte(len(c.locals)-1))
+		c.emitOp(OP_THROW)
+		c.endScope()
+		c.patchJump(skipHandler)
+	}
+
+	c.endScope()
+}
+
+/*----------  Jump Out  ----------*/
+
+// emit code which leaves tries and pops locals inside b,
+// the jump itself is emitted by caller
+func (c *Compiler) jumpOut(b *breakable) {
+	top := c.exitTries(b.tryCount, len(c.locals))
+	c.popLocals(top, b.localCount)
+}
+
+// leave tries from innermost until count tries are left, top is the count
+// of locals on stack, return the count after leaving
+func (c *Compiler) exitTries(count int, top int) int {
+	for i := len(c.tries) - 1; i >= count; i-- {
+		t := c.tries[i]
+		c.popLocals(top, t.localCount)
+		top = t.localCount
+		c.emitOp(OP_END_TRY)
+		if t.finally != nil {
+			c.inlineFinally(i)
+		}
+	}
+	return top
+}
+
+// the return value is on stack top
+func (c *Compiler) returnThroughTries() {
+	returnSlot := -1
+	for _, t := range c.tries {
+		if t.finally != nil {
+			returnSlot = t.returnSlot
+			break
+		}
+	}
+
+	// only handlers to remove, return discards the stack anyway
+	if returnSlot < 0 {
+		for range c.tries {
+			c.emitOp(OP_END_TRY)
+		}
+		c.emitOp(OP_RETURN)
+		return
+	}
+
+	c.emitOpByte(OP_SET_LOCAL, byte(returnSlot))
+	c.emitOp(OP_POP)
+	c.exitTries(0, len(c.locals))
+	c.emitOpByte(OP_GET_LOCAL, byte(returnSlot))
+	c.emitOp(OP_RETURN)
+}
+
+// compile finally of tries[i] at the current position, it sees only
+// locals, loops and tries outside the try statement
+func (c *Compiler) inlineFinally(i int) {
+	t := c.tries[i]
+	locals, tries, breakables := c.locals, c.tries, c.breakables
+
+	c.locals = append([]local(nil), locals[:t.localCount]...)
+	c.tries = append([]*tryBlock(nil), tries[:i]...)
+	c.breakables = nil
+	for _, b := range breakables {
+		if b.tryCount <= i {
+			c.breakables = append(c.breakables, b)
+		}
+	}
+
+	c.compileStmt(t.finally)
+
+	for j := 0; j < t.localCount; j++ {
+		locals[j].captured = locals[j].captured || c.locals[j].captured
+	}
+	c.locals, c.tries, c.breakables = locals, tries, breakables
+}
+
+func (c *Compiler) beginBreakable(isLoop bool) *breakable {
+	b := &breakable{isLoop: isLoop, localCount: len(c.locals), tryCount: len(c.tries)}
+	c.breakables = append(c.breakables, b)
+	return b
+}
+
+func (c *Compiler) endBreakable() {
+	c.breakables = c.breakables[:len(c.breakables)-1]
+}
+
+/*----------  Expr  ----------*/
+
+var binaryOpCodes = map[scanner.TokenType]OpCode{
+	scanner.EQUAL_EQUAL:     OP_EQUAL,
+	scanner.BANG_EQUAL:      OP_NOT_EQUAL,
+	scanner.GREATER:         OP_GREATER,
+	scanner.GREATER_EQUAL:   OP_GREATER_EQUAL,
+	scanner.LESS:            OP_LESS,
+	scanner.LESS_EQUAL:      OP_LESS_EQUAL,
+	scanner.PLUS:            OP_ADD,
+	scanner.MINUS:           OP_SUBTRACT,
+	scanner.STAR:            OP_MULTIPLY,
+	scanner.SLASH:           OP_DIVIDE,
+	scanner.TILDE_SLASH:     OP_INT_DIVIDE,
+	scanner.PERCENT:         OP_MODULO,
+	scanner.STAR_STAR:       OP_POWER,
+	scanner.AMPERSAND:       OP_BIT_AND,
+	scanner.PIPE:            OP_BIT_OR,
+	scanner.CARET:           OP_BIT_XOR,
+	scanner.LESS_LESS:       OP_SHIFT_LEFT,
+	scanner.GREATER_GREATER: OP_SHIFT_RIGHT,
+}
+
+func (c *Compiler) compileExpr(expr Expr) {
+	switch e := expr.(type) {
+	case *ExprLiteral:
+		switch e.value {
+		case nil:
+			c.emitOp(OP_NIL)
+		case true:
+			c.emitOp(OP_TRUE)
+		case false:
+			c.emitOp(OP_FALSE)
+		default:
+			c.emitOpShort(OP_CONSTANT, c.makeConstant(e.value))
+		}
+	case *ExprInterpolation:
+		for _, part := range e.parts {
+			c.compileExpr(part)
+		}
+		c.emitOpShort(OP_INTERPOLATE, len(e.parts))
+	case *ExprVariable:
+		c.namedVariable(e.name, false)
+	case *ExprAssignment:
+		c.compileExpr(e.val)
+		c.namedVariable(e.name, true)
+	case *ExprCompoundAssign:
+		c.namedVariable(e.name, false)
+		c.compileExpr(e.val)
+		c.line = e.operator.Line
+		c.emitOp(binaryOpCodes[compoundOperators[e.operator.Type]])
+		c.namedVariable(e.name, true)
+	case *ExprIncrement:
+		c.namedVariable(e.name, false)
+		if !e.prefix {
+			c.emitOp(OP_DUP)
+		}
+		c.line = e.operator.Line
+		if e.operator.Type == scanner.PLUS_PLUS {
+			c.emitOp(OP_INCREMENT)
+		} else {
+			c.emitOp(OP_DECREMENT)
+		}
+		c.namedVariable(e.name, true)
+		// postfix leaves the old value
+		if !e.prefix {
+			c.emitOp(OP_POP)
+		}
+	case *ExprUnary:
+		c.compileExpr(e.operand)
+		c.line = e.operator.Line
+		switch e.operator.Type {
+		case scanner.BANG:
+			c.emitOp(OP_NOT)
+		case scanner.MINUS:
+			c.emitOp(OP_NEGATE)
+		case scanner.TILDE:
+			c.emitOp(OP_BIT_NOT)
+		}
+	case *ExprBinary:
+		c.compileExpr(e.left)
+		c.compileExpr(e.right)
+		c.line = e.operator.Line
+		c.emitOp(binaryOpCodes[e.operator.Type])
+	case *ExprGrouping:
+		c.compileExpr(e.operand)
+	case *ExprLogical:
+		c.compileExpr(e.left)
+		if e.operator.Type == scanner.AND {
+			endJump := c.emitJump(OP_JUMP_IF_FALSE)
+			c.emitOp(OP_POP)
+			c.compileExpr(e.right)
+			c.patchJump(endJump)
+		} else {
+			elseJump := c.emitJump(OP_JUMP_IF_FALSE)
+			endJump := c.emitJump(OP_JUMP)
+			c.patchJump(elseJump)
+			c.emitOp(OP_POP)
+			c.compileExpr(e.right)
+			c.patchJump(endJump)
+		}
+	case *ExprTernary:
+		c.compileExpr(e.condition)
+		elseJump := c.emitJump(OP_JUMP_IF_FALSE)
+		c.emitOp(OP_POP)
+		c.compileExpr(e.thenBranch)
+		endJump := c.emitJump(OP_JUMP)
+		c.patchJump(elseJump)
+		c.emitOp(OP_POP)
+		c.compileExpr(e.elseBranch)
+		c.patchJump(endJump)
+	case *ExprComma:
+		c.compileExpr(e.left)
+		c.emitOp(OP_POP)
+		c.compileExpr(e.right)
+	case *ExprCall:
+		c.compileExpr(e.callee)
+		for _, arg := range e.arguments {
+			c.compileExpr(arg)
+		}
+		c.line = e.paren.Line
+		if len(e.arguments) > 255 {
+			c.error("can't have more than 255 arguments")
+		}
+		c.emitOpByte(OP_CALL, byte(len(e.arguments)))
+	case *ExprFunction:
+		c.compileFunction(e.declaration, FunctionTypeFunction)
+	case *ExprGet:
+		c.compileExpr(e.object)
+		c.line = e.name.Line
+		c.emitOpShort(OP_GET_PROPERTY, c.identifierConstant(e.name.Lexeme))
+	case *ExprSet:
+		c.compileExpr(e.object)
+		c.compileExpr(e.val)
+		c.line = e.name.Line
+		c.emitOpShort(OP_SET_PROPERTY, c.identifierConstant(e.name.Lexeme))
+	case *ExprThis:
+		c.namedVariable(e.keyword, false)
+	case *ExprSuper:
+		this := &scanner.Token{Type: scanner.THIS, Lexeme: "this", Line: e.keyword.Line}
+		c.namedVariable(this, false)
+		c.namedVariable(e.keyword, false)
+		c.line = e.method.Line
+		c.emitOpShort(OP_GET_SUPER, c.identifierConstant(e.method.Lexeme))
+	case *ExprList:
+		for _, element := range e.elements {
+			c.compileExpr(element)
+		}
+		c.emitOpShort(OP_LIST, len(e.elements))
+	case *ExprMap:
+		for i := range e.keys {
+			c.compileExpr(e.keys[i])
+			c.compileExpr(e.values[i])
+		}
+		c.line = e.brace.Line
+		c.emitOpShort(OP_MAP, len(e.keys))
+	case *ExprIndex:
+		c.compileExpr(e.object)
+		c.compileExpr(e.index)
+		c.line = e.bracket.Line
+		c.emitOp(OP_INDEX)
+	case *ExprIndexSet:
+		c.compileExpr(e.object)
+		c.compileExpr(e.index)
+		c.compileExpr(e.val)
+		c.line = e.bracket.Line
+		c.emitOp(OP_INDEX_SET)
+	case *ExprSlice:
+		c.compileExpr(e.object)
+		for _, bound := range []Expr{e.start, e.end} {
+			if bound != nil {
+				c.compileExpr(bound)
+			} else {
+				c.emitOp(OP_NIL)
+			}
+		}
+		c.line = e.bracket.Line
+		c.emitOp(OP_SLICE)
+	default:
+		panic(sprintf("compiler: unknown expr %T", expr))
+	}
+}
+
+/*----------  Variables  ----------*/
+
+// get or set the variable, value to set is on stack top
+func (c *Compiler) namedVariable(name *scanner.Token, set bool) {
+	c.line = name.Line
+	getOp, setOp := OP_GET_GLOBAL, OP_SET_GLOBAL
+	var arg int
+	if slot := c.resolveLocal(name.Lexeme); slot >= 0 {
+		getOp, setOp, arg = OP_GET_LOCAL, OP_SET_LOCAL, slot
+	} else if index := c.resolveUpvalue(name.Lexeme); index >= 0 {
+		getOp, setOp, arg = OP_GET_UPVALUE, OP_SET_UPVALUE, index
+	} else {
+		arg = c.identifierConstant(name.Lexeme)
+	}
+
+	op := getOp
+	if set {
+		op = setOp
+	}
+	if op == OP_GET_GLOBAL || op == OP_SET_GLOBAL {
+		c.emitOpShort(op, arg)
+	} else {
+		c.emitOpByte(op, byte(arg))
+	}
+}
+
+// value of the variable is on stack top
+func (c *Compiler) defineVariable(name *scanner.Token) {
+	if c.scopeDepth > 0 {
+		c.addLocal(name.Lexeme)
+		return
+	}
+	c.line = name.Line
+	c.emitOpShort(OP_DEFINE_GLOBAL, c.identifierConstant(name.Lexeme))
+}
+
+func (c *Compiler) addLocal(name string) {
+	if len(c.locals) >= maxLocals {
+		c.error("too many local variables in function")
+		return
+	}
+	c.locals = append(c.locals, local{name, c.scopeDepth, false})
+}
+
+func (c *Compiler) resolveLocal(name string) int {
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if c.locals[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Compiler) resolveUpvalue(name string) int {
+	if c.enclosing == nil {
+		return -1
+	}
+	if slot := c.enclosing.resolveLocal(name); slot >= 0 {
+		c.enclosing.locals[slot].captured = true
+		return c.addUpvalue(slot, true)
+	}
+	if index := c.enclosing.resolveUpvalue(name); index >= 0 {
+		return c.addUpvalue(index, false)
+	}
+	return -1
+}
+
+func (c *Compiler) addUpvalue(index int, isLocal bool) int {
+	for i, upvalue := range c.upvalues {
+		if upvalue.index == index && upvalue.isLocal == isLocal {
+			return i
+		}
+	}
+	if len(c.upvalues) >= maxUpvalues {
+		c.error("too many closure variables in function")
+		return 0
+	}
+	c.upvalues = append(c.upvalues, upvalueRef{index, isLocal})
+	return len(c.upvalues) - 1
+}
+
+func (c *Compiler) beginScope() {
+	c.scopeDepth++
+}
+
+func (c *Compiler) endScope() {
+	c.scopeDepth--
+	n := len(c.locals)
+	for n > 0 && c.locals[n-1].depth > c.scopeDepth {
+		n--
+	}
+	c.popLocals(len(c.locals), n)
+	c.locals = c.locals[:n]
+}
+
+// emit code to pop locals from top down to count, captured ones
+// are moved to heap, the locals are still known to compiler
+func (c *Compiler) popLocals(top int, count int) {
+	for i := top - 1; i >= count; i-- {
+		if c.locals[i].captured {
+			c.emitOp(OP_CLOSE_UPVALUE)
+		} else {
+			c.emitOp(OP_POP)
+		}
+	}
+}
+
+/*----------  Helper Methods  ----------*/
+
+func (c *Compiler) chunk() *Chunk {
+	return c.function.chunk
+}
+
+func (c *Compiler) error(msg string) {
+	*c.errors = append(*c.errors, &CompileError{c.line, msg})
+}
+
+func (c *Compiler) emitBytes(bytes ...byte) {
+	for _, b := range bytes {
+		c.chunk().write(b, c.line)
+	}
+}
+
+func (c *Compiler) emitOp(op OpCode) {
+	c.emitBytes(byte(op))
+}
+
+func (c *Compiler) emitOpByte(op OpCode, operand byte) {
+	c.emitBytes(byte(op), operand)
+}
+
+func (c *Compiler) emitOpShort(op OpCode, operand int) {
+	c.emitBytes(byte(op), byte(operand>>8), byte(operand))
+}
+
+// implicit return, initializer returns `this`
+func (c *Compiler) emitReturn() {
+	if c.typ == FunctionTypeInitializer {
+		c.emitOpByte(OP_GET_LOCAL, 0)
+	} else {
+		c.emitOp(OP_NIL)
+	}
+	c.emitOp(OP_RETURN)
+}
+
+// return offset of the operand to patch
+func (c *Compiler) emitJump(op OpCode) int {
+	c.emitBytes(byte(op), 0xff, 0xff)
+	return len(c.chunk().code) - 2
+}
+
+// jump to current position
+func (c *Compiler) patchJump(offset int) {
+	jump := len(c.chunk().code) - offset - 2
+	if jump > maxJump {
+		c.error("too much code to jump over")
+	}
+	c.chunk().code[offset] = byte(jump >> 8)
+	c.chunk().code[offset+1] = byte(jump)
+}
+
+func (c *Compiler) patchJumps(offsets []int) {
+	for _, offset := range offsets {
+		c.patchJump(offset)
+	}
+}
+
+func (c *Compiler) emitLoop(loopStart int) {
+	c.emitOp(OP_LOOP)
+	jump := len(c.chunk().code) - loopStart + 2
+	if jump > maxJump {
+		c.error("loop body too large")
+	}
+	c.emitBytes(byte(jump>>8), byte(jump))
+}
+
+func (c *Compiler) makeConstant(val Val) int {
+	if len(c.chunk().constants) >= maxConstants {
+		c.error("too many constants in one chunk")
+		return 0
+	}
+	return c.chunk().addConstant(val)
+}
+
+// identifiers are stored once per chunk
+func (c *Compiler) identifierConstant(name string) int {
+	if index, ok := c.names[name]; ok {
+		return index
+	}
+	index := c.makeConstant(name)
+	c.names[name] = index
+	return index
+}
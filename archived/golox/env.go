@@ -2,52 +2,86 @@ package main
 
 import "cjting.me/lox/scanner"
 
+// Env is a scope at runtime, local variables live in slots, indexed in
+// declaration order, which is the slot resolver gives them. Global
+// variables are looked up by name in the global env
 type Env struct {
-	prev *Env
-	m    map[string]Val
+	prev  *Env
+	slots []Val
+	// the outermost env
+	root *Env
+	// only set for global env
+	globals map[string]Val
+	module  *LoxModule
 }
 
 func NewEnv(prev *Env) *Env {
-	return &Env{
-		prev,
-		map[string]Val{},
+	env := &Env{prev: prev}
+	if prev != nil {
+		env.root = prev.root
+	} else {
+		env.root = env
+		env.globals = map[string]Val{}
 	}
+	return env
 }
 
+// local variable takes the next slot, name is only used by global env
 func (e *Env) Define(name string, val Val) {
-	e.m[name] = val
+	if e.globals != nil {
+		e.globals[name] = val
+		return
+	}
+	e.slots = append(e.slots, val)
 }
 
+// get global variable
 func (e *Env) Get(name *scanner.Token) Val {
 	key := name.Lexeme
-	if e.has(key) {
-		return e.m[key]
-	}
-
-	if e.prev != nil {
-		return e.prev.Get(name)
+	if val, ok := e.root.globals[key]; ok {
+		return val
 	}
-
 	panic(NewRuntimeError(name, sprintf("undefined variable '%s'", key)))
 }
 
-func (e Env) Set(name *scanner.Token, val Val) {
+// set global variable, it must be defined
+func (e *Env) Set(name *scanner.Token, val Val) {
 	key := name.Lexeme
-
-	if e.has(key) {
-		e.m[key] = val
+	if _, ok := e.root.globals[key]; ok {
+		e.root.globals[key] = val
 		return
 	}
+	panic(NewRuntimeError(name, sprintf("undefined variable '%s'", key)))
+}
+
+// get local variable from the env which is `depth` levels up,
+// depth and slot are computed by the resolver
+func (e *Env) GetAt(depth int, slot int, name *scanner.Token) Val {
+	env := e.ancestor(depth)
+	if slot < len(env.slots) {
+		return env.slots[slot]
+	}
+	panic(NewRuntimeError(name, sprintf("undefined variable '%s'", name.Lexeme)))
+}
 
-	if e.prev != nil {
-		e.prev.Set(name, val)
+func (e *Env) SetAt(depth int, slot int, name *scanner.Token, val Val) {
+	env := e.ancestor(depth)
+	if slot < len(env.slots) {
+		env.slots[slot] = val
 		return
 	}
+	panic(NewRuntimeError(name, sprintf("undefined variable '%s'", name.Lexeme)))
+}
 
-	panic(NewRuntimeError(name, sprintf("undefined variable '%s'", key)))
+func (e *Env) ancestor(depth int) *Env {
+	env := e
+	for i := 0; i < depth; i++ {
+		env = env.prev
+	}
+	return env
 }
 
-func (e Env) has(key string) bool {
-	_, ok := e.m[key]
-	return ok
+// the outermost env, which holds global variables
+func (e *Env) global() *Env {
+	return e.root
 }
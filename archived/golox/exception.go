@@ -0,0 +1,43 @@
+package main
+
+import "cjting.me/lox/scanner"
+
+// Throw carries a value thrown by `throw`, like RuntimeError it unwinds
+// with panic until it's caught by `try` or reaches the top level
+type Throw struct {
+	token *scanner.Token
+	value Val
+}
+
+func NewThrow(token *scanner.Token, value Val) *Throw {
+	return &Throw{token, value}
+}
+
+func (t *Throw) Error() string {
+	return sprintf("line %d, uncaught exception: %s", t.token.Line, stringify(t.value))
+}
+
+// LoxError is how a RuntimeError is seen by Lox code in `catch`
+type LoxError struct {
+	message string
+	line    int
+}
+
+func NewLoxError(re *RuntimeError) *LoxError {
+	return &LoxError{re.msg, re.token.Line}
+}
+
+func (e *LoxError) String() string {
+	return sprintf("line %d, %s", e.line, e.message)
+}
+
+// only `message` and `line` are available
+func (e *LoxError) Get(name *scanner.Token) Val {
+	switch name.Lexeme {
+	case "message":
+		return e.message
+	case "line":
+		return scanner.Number(e.line)
+	}
+	panic(NewRuntimeError(name, sprintf("undefined property '%s'", name.Lexeme)))
+}
@@ -14,10 +14,13 @@ type Expr interface {
 /*----------  Variable  ----------*/
 type ExprVariable struct {
 	name *scanner.Token
+	// set by resolver, depth -1 means global variable
+	depth int
+	slot  int
 }
 
 func NewExprVariable(name *scanner.Token) *ExprVariable {
-	return &ExprVariable{name}
+	return &ExprVariable{name, -1, 0}
 }
 
 func (expr *ExprVariable) Print() string {
@@ -38,6 +41,21 @@ func (expr *ExprLiteral) Print() string {
 	return sprintf("%#v", expr.value)
 }
 
+/*----------  String Interpolation  ----------*/
+
+// parts are string literals and interpolated expressions
+type ExprInterpolation struct {
+	parts []Expr
+}
+
+func NewExprInterpolation(parts []Expr) *ExprInterpolation {
+	return &ExprInterpolation{parts}
+}
+
+func (expr *ExprInterpolation) Print() string {
+	return parenthesize("interpolate", expr.parts...)
+}
+
 /*----------  Unary  ----------*/
 
 type ExprUnary struct {
@@ -87,14 +105,61 @@ func (expr *ExprGrouping) Print() string {
 type ExprAssignment struct {
 	name *scanner.Token
 	val  Expr
+	// set by resolver, depth -1 means global variable
+	depth int
+	slot  int
 }
 
 func NewExprAssignment(name *scanner.Token, val Expr) *ExprAssignment {
-	return &ExprAssignment{name, val}
+	return &ExprAssignment{name, val, -1, 0}
 }
 
 func (expr *ExprAssignment) Print() string {
-	return sprintf("(assign %s %v)", expr.name.Lexeme, expr.val)
+	return sprintf("(assign %s %s)", expr.name.Lexeme, expr.val.Print())
+}
+
+/*----------  Compound Assignment  ----------*/
+
+// `a += 1`, only variables can be the target
+type ExprCompoundAssign struct {
+	name     *scanner.Token
+	operator *scanner.Token
+	val      Expr
+	// set by resolver, depth -1 means global variable
+	depth int
+	slot  int
+}
+
+func NewExprCompoundAssign(name *scanner.Token, operator *scanner.Token, val Expr) *ExprCompoundAssign {
+	return &ExprCompoundAssign{name, operator, val, -1, 0}
+}
+
+func (expr *ExprCompoundAssign) Print() string {
+	return sprintf("(%s %s %s)", expr.operator.Lexeme, expr.name.Lexeme, expr.val.Print())
+}
+
+/*----------  Increment  ----------*/
+
+// `++a`, `a--`, only variables can be the target
+type ExprIncrement struct {
+	name     *scanner.Token
+	operator *scanner.Token
+	// prefix evaluates to the new value, postfix to the old one
+	prefix bool
+	// set by resolver, depth -1 means global variable
+	depth int
+	slot  int
+}
+
+func NewExprIncrement(name *scanner.Token, operator *scanner.Token, prefix bool) *ExprIncrement {
+	return &ExprIncrement{name, operator, prefix, -1, 0}
+}
+
+func (expr *ExprIncrement) Print() string {
+	if expr.prefix {
+		return sprintf("(%s %s)", expr.operator.Lexeme, expr.name.Lexeme)
+	}
+	return sprintf("(%s %s)", expr.name.Lexeme, expr.operator.Lexeme)
 }
 
 /*----------  Logical  ----------*/
@@ -112,6 +177,35 @@ func (expr *ExprLogical) Print() string {
 	return parenthesize(expr.operator.Lexeme, expr.left, expr.right)
 }
 
+/*----------  Ternary  ----------*/
+type ExprTernary struct {
+	condition  Expr
+	thenBranch Expr
+	elseBranch Expr
+}
+
+func NewExprTernary(condition, thenBranch, elseBranch Expr) *ExprTernary {
+	return &ExprTernary{condition, thenBranch, elseBranch}
+}
+
+func (expr *ExprTernary) Print() string {
+	return parenthesize("?:", expr.condition, expr.thenBranch, expr.elseBranch)
+}
+
+/*----------  Comma  ----------*/
+type ExprComma struct {
+	left  Expr
+	right Expr
+}
+
+func NewExprComma(left, right Expr) *ExprComma {
+	return &ExprComma{left, right}
+}
+
+func (expr *ExprComma) Print() string {
+	return parenthesize(",", expr.left, expr.right)
+}
+
 /*----------  Function Call  ----------*/
 type ExprCall struct {
 	callee Expr
@@ -128,6 +222,169 @@ func (expr *ExprCall) Print() string {
 	return parenthesize(expr.callee.Print(), expr.arguments...)
 }
 
+/*----------  Anonymous Function  ----------*/
+type ExprFunction struct {
+	// name of declaration is nil
+	declaration *StmtFuncDecl
+}
+
+func NewExprFunction(declaration *StmtFuncDecl) *ExprFunction {
+	return &ExprFunction{declaration}
+}
+
+func (expr *ExprFunction) Print() string {
+	return expr.declaration.Print()
+}
+
+/*----------  Property Get  ----------*/
+type ExprGet struct {
+	object Expr
+	name   *scanner.Token
+}
+
+func NewExprGet(object Expr, name *scanner.Token) *ExprGet {
+	return &ExprGet{object, name}
+}
+
+func (expr *ExprGet) Print() string {
+	return parenthesize("get "+expr.name.Lexeme, expr.object)
+}
+
+/*----------  Property Set  ----------*/
+type ExprSet struct {
+	object Expr
+	name   *scanner.Token
+	val    Expr
+}
+
+func NewExprSet(object Expr, name *scanner.Token, val Expr) *ExprSet {
+	return &ExprSet{object, name, val}
+}
+
+func (expr *ExprSet) Print() string {
+	return parenthesize("set "+expr.name.Lexeme, expr.object, expr.val)
+}
+
+/*----------  This  ----------*/
+type ExprThis struct {
+	keyword *scanner.Token
+	// set by resolver, `this` is always in slot 0
+	depth int
+}
+
+func NewExprThis(keyword *scanner.Token) *ExprThis {
+	return &ExprThis{keyword, -1}
+}
+
+func (expr *ExprThis) Print() string {
+	return "this"
+}
+
+/*----------  Super  ----------*/
+type ExprSuper struct {
+	keyword *scanner.Token
+	method  *scanner.Token
+	// set by resolver, `super` is always in slot 0
+	depth int
+}
+
+func NewExprSuper(keyword *scanner.Token, method *scanner.Token) *ExprSuper {
+	return &ExprSuper{keyword, method, -1}
+}
+
+func (expr *ExprSuper) Print() string {
+	return "(super " + expr.method.Lexeme + ")"
+}
+
+/*----------  List Literal  ----------*/
+type ExprList struct {
+	elements []Expr
+}
+
+func NewExprList(elements []Expr) *ExprList {
+	return &ExprList{elements}
+}
+
+func (expr *ExprList) Print() string {
+	return parenthesize("list", expr.elements...)
+}
+
+/*----------  Map Literal  ----------*/
+type ExprMap struct {
+	// open brace
+	brace  *scanner.Token
+	keys   []Expr
+	values []Expr
+}
+
+func NewExprMap(brace *scanner.Token, keys []Expr, values []Expr) *ExprMap {
+	return &ExprMap{brace, keys, values}
+}
+
+func (expr *ExprMap) Print() string {
+	var entries []Expr
+	for i := range expr.keys {
+		entries = append(entries, expr.keys[i], expr.values[i])
+	}
+	return parenthesize("map", entries...)
+}
+
+/*----------  Index Get  ----------*/
+type ExprIndex struct {
+	object Expr
+	// close bracket
+	bracket *scanner.Token
+	index   Expr
+}
+
+func NewExprIndex(object Expr, bracket *scanner.Token, index Expr) *ExprIndex {
+	return &ExprIndex{object, bracket, index}
+}
+
+func (expr *ExprIndex) Print() string {
+	return parenthesize("index", expr.object, expr.index)
+}
+
+/*----------  Index Set  ----------*/
+type ExprIndexSet struct {
+	object  Expr
+	bracket *scanner.Token
+	index   Expr
+	val     Expr
+}
+
+func NewExprIndexSet(object Expr, bracket *scanner.Token, index Expr, val Expr) *ExprIndexSet {
+	return &ExprIndexSet{object, bracket, index, val}
+}
+
+func (expr *ExprIndexSet) Print() string {
+	return parenthesize("index-set", expr.object, expr.index, expr.val)
+}
+
+/*----------  Slice  ----------*/
+type ExprSlice struct {
+	object  Expr
+	bracket *scanner.Token
+	// start and end are optional
+	start Expr
+	end   Expr
+}
+
+func NewExprSlice(object Expr, bracket *scanner.Token, start, end Expr) *ExprSlice {
+	return &ExprSlice{object, bracket, start, end}
+}
+
+func (expr *ExprSlice) Print() string {
+	start, end := "_", "_"
+	if expr.start != nil {
+		start = expr.start.Print()
+	}
+	if expr.end != nil {
+		end = expr.end.Print()
+	}
+	return sprintf("(slice %s %s %s)", expr.object.Print(), start, end)
+}
+
 /*----------  Helper Methods  ----------*/
 
 func parenthesize(name string, exprs ...Expr) string {
@@ -1,13 +1,36 @@
 package main
 
-import "time"
+import (
+	"time"
 
-// global env
+	"cjting.me/lox/scanner"
+)
 
-var globalEnv = NewEnv(nil)
+// global env, every module has its own one
 
-func init() {
-	globalEnv.Define("clock", NewFunction(0, func(_ *Env, _ []Val) Val {
+func NewGlobalEnv(module *LoxModule) *Env {
+	env := NewEnv(nil)
+	env.module = module
+
+	env.Define("clock", NewFunction(0, func(_ *Env, _ *scanner.Token, _ []Val) Val {
 		return time.Now().Unix()
 	}))
+
+	// list
+	env.Define("len", NewFunction(1, nativeLen))
+	env.Define("push", NewFunction(2, nativePush))
+	env.Define("pop", NewFunction(1, nativePop))
+	env.Define("insert", NewFunction(3, nativeInsert))
+	env.Define("remove", NewFunction(2, nativeRemove))
+
+	// iteration
+	env.Define("range", NewFunction(3, nativeRange))
+
+	// map
+	env.Define("keys", NewFunction(1, nativeKeys))
+	env.Define("values", NewFunction(1, nativeValues))
+	env.Define("has", NewFunction(2, nativeHas))
+	env.Define("delete", NewFunction(2, nativeDelete))
+
+	return env
 }
@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
 	"cjting.me/lox/scanner"
 )
@@ -17,94 +20,278 @@ func NewRuntimeError(token *scanner.Token, msg string) *RuntimeError {
 	return &RuntimeError{token, msg}
 }
 
-// we use exception as control flow
-type FunctionReturn struct {
-	value Val
+func (re *RuntimeError) Error() string {
+	return fmt.Sprintf("line %d, %s", re.token.Line, re.msg)
 }
 
-func NewFunctionReturn(value Val) *FunctionReturn {
-	return &FunctionReturn{value}
-}
+// how a statement finishes, return, break and continue are passed up
+// through Run until a function or a loop handles them,
+// exceptions still unwind with panic
+type CompletionType int
 
-func (re *RuntimeError) Error() string {
-	return fmt.Sprintf("line %d, %s", re.token.Line, re.msg)
+const (
+	CompletionNormal CompletionType = iota
+	CompletionReturn
+	CompletionBreak
+	CompletionContinue
+)
+
+type Completion struct {
+	typ CompletionType
+	// only for return
+	value Val
 }
 
+var normalCompletion = Completion{}
+
 /*----------  Stmt: Print  ----------*/
 
-func (s *StmtPrint) Run(env *Env) {
+func (s *StmtPrint) Run(env *Env) Completion {
 	val := s.expr.Eval(env)
-	fmt.Println(val)
+	fmt.Fprintln(stdout, stringify(val))
+	return normalCompletion
 }
 
 /*----------  Stmt: Expression  ----------*/
 
-func (s *StmtExpression) Run(env *Env) {
+func (s *StmtExpression) Run(env *Env) Completion {
 	s.expr.Eval(env)
+	return normalCompletion
 }
 
 /*----------  Stmt: Variable Declaration  ----------*/
 
-func (s *StmtVarDecl) Run(env *Env) {
+func (s *StmtVarDecl) Run(env *Env) Completion {
 	var val Val
 	if s.value != nil {
 		val = s.value.Eval(env)
 	}
 	env.Define(s.name.Lexeme, val)
+	return normalCompletion
 }
 
 /*----------  Stmt: Block  ----------*/
 
-func (s *StmtBlock) Run(env *Env) {
-	newEnv := NewEnv(env)
-	for _, stmt := range s.stmts {
-		stmt.Run(newEnv)
+func (s *StmtBlock) Run(env *Env) Completion {
+	return runStmts(s.stmts, NewEnv(env))
+}
+
+// stop at the first statement which doesn't complete normally
+func runStmts(stmts []Stmt, env *Env) Completion {
+	for _, stmt := range stmts {
+		if c := stmt.Run(env); c.typ != CompletionNormal {
+			return c
+		}
 	}
+	return normalCompletion
 }
 
 /*----------  Stmt: If  ----------*/
 
-func (s *StmtIf) Run(env *Env) {
+func (s *StmtIf) Run(env *Env) Completion {
 	val := s.condition.Eval(env)
 	if getTruthy(val) {
-		s.trueBranch.Run(env)
-	} else {
-		if s.falseBranch != nil {
-			s.falseBranch.Run(env)
-		}
+		return s.trueBranch.Run(env)
+	}
+	if s.falseBranch != nil {
+		return s.falseBranch.Run(env)
 	}
+	return normalCompletion
 }
 
 /*----------  Stmt: While  ----------*/
 
-func (s *StmtWhile) Run(env *Env) {
+func (s *StmtWhile) Run(env *Env) Completion {
 	for getTruthy(s.condition.Eval(env)) {
-		s.body.Run(env)
+		c := s.body.Run(env)
+		if c.typ == CompletionBreak {
+			break
+		}
+		if c.typ == CompletionReturn {
+			return c
+		}
+		if s.increment != nil {
+			s.increment.Eval(env)
+		}
 	}
+	return normalCompletion
+}
+
+/*----------  Stmt: For In  ----------*/
+
+func (s *StmtForIn) Run(env *Env) Completion {
+	iterator := iterate(s.in, s.iterable.Eval(env))
+	for {
+		val, ok := iterator.Next()
+		if !ok {
+			break
+		}
+		// closures capture the variable of their own iteration
+		loopEnv := NewEnv(env)
+		loopEnv.Define(s.name.Lexeme, val)
+		c := s.body.Run(loopEnv)
+		if c.typ == CompletionBreak {
+			break
+		}
+		if c.typ == CompletionReturn {
+			return c
+		}
+	}
+	return normalCompletion
+}
+
+/*----------  Stmt: Switch  ----------*/
+
+func (s *StmtSwitch) Run(env *Env) Completion {
+	value := s.value.Eval(env)
+	for _, c := range s.cases {
+		for _, v := range c.values {
+			if v.Eval(env) == value {
+				return runSwitchBody(c.body, env)
+			}
+		}
+	}
+	if s.defaultBody != nil {
+		return runSwitchBody(s.defaultBody, env)
+	}
+	return normalCompletion
+}
+
+// break exits the switch, continue goes to the enclosing loop
+func runSwitchBody(body *StmtBlock, env *Env) Completion {
+	c := body.Run(env)
+	if c.typ == CompletionBreak {
+		return normalCompletion
+	}
+	return c
+}
+
+/*----------  Stmt: Break  ----------*/
+
+func (s *StmtBreak) Run(env *Env) Completion {
+	return Completion{typ: CompletionBreak}
+}
+
+/*----------  Stmt: Continue  ----------*/
+
+func (s *StmtContinue) Run(env *Env) Completion {
+	return Completion{typ: CompletionContinue}
 }
 
 /*----------  Stmt: Function Declaration  ----------*/
 
-func (s *StmtFuncDecl) Run(env *Env) {
-	s.closure = env
-	env.Define(s.name.Lexeme, s)
+func (s *StmtFuncDecl) Run(env *Env) Completion {
+	env.Define(s.name.Lexeme, NewLoxFunction(s, env, false))
+	return normalCompletion
+}
+
+/*----------  Stmt: Class Declaration  ----------*/
+
+func (s *StmtClassDecl) Run(env *Env) Completion {
+	var superclass *LoxClass
+	if s.superclass != nil {
+		val := s.superclass.Eval(env)
+		class, ok := val.(*LoxClass)
+		if !ok {
+			panic(NewRuntimeError(s.superclass.name, "superclass must be a class"))
+		}
+		superclass = class
+	}
+
+	// methods of a subclass close over an extra env which holds `super`
+	closure := env
+	if superclass != nil {
+		closure = NewEnv(env)
+		closure.Define("super", superclass)
+	}
+
+	methods := map[string]*LoxFunction{}
+	for _, decl := range s.methods {
+		isInitializer := decl.name.Lexeme == "init"
+		methods[decl.name.Lexeme] = NewLoxFunction(decl, closure, isInitializer)
+	}
+
+	env.Define(s.name.Lexeme, NewLoxClass(s.name.Lexeme, superclass, methods))
+	return normalCompletion
 }
 
 /*----------  Stmt: Return  ----------*/
 
-func (s *StmtReturn) Run(env *Env) {
+func (s *StmtReturn) Run(env *Env) Completion {
 	var value Val
 	if s.value != nil {
 		value = s.value.Eval(env)
 	}
-	panic(NewFunctionReturn(value))
+	return Completion{CompletionReturn, value}
+}
+
+/*----------  Stmt: Throw  ----------*/
+
+func (s *StmtThrow) Run(env *Env) Completion {
+	panic(NewThrow(s.keyword, s.value.Eval(env)))
+}
+
+/*----------  Stmt: Try  ----------*/
+
+// finally runs no matter how the try statement is left, if finally itself
+// returns, breaks or continues, that wins, even over an exception in flight
+func (s *StmtTry) Run(env *Env) (completion Completion) {
+	if s.finallyBody != nil {
+		defer func() {
+			if c := s.finallyBody.Run(env); c.typ != CompletionNormal {
+				recover()
+				completion = c
+			}
+		}()
+	}
+
+	if s.catchName == nil {
+		return s.body.Run(env)
+	}
+
+	completion, value, caught := s.runBody(env)
+	if caught {
+		catchEnv := NewEnv(env)
+		catchEnv.Define(s.catchName.Lexeme, value)
+		return runStmts(s.catchBody, catchEnv)
+	}
+	return completion
+}
+
+// return the error value if body throws,
+// errors thrown by catch body are not caught
+func (s *StmtTry) runBody(env *Env) (completion Completion, value Val, caught bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch err := e.(type) {
+			case *RuntimeError:
+				value, caught = NewLoxError(err), true
+			case *Throw:
+				value, caught = err.value, true
+			default:
+				panic(e)
+			}
+		}
+	}()
+	return s.body.Run(env), nil, false
+}
+
+/*----------  Stmt: Import  ----------*/
+
+// relative path is resolved against the file where the import is written,
+// which is the module owning the global env
+func (s *StmtImport) Run(env *Env) Completion {
+	importer := env.global().module
+	module := importer.lox.importModule(importer, s.path)
+	env.Define(s.name.Lexeme, module)
+	return normalCompletion
 }
 
 /*----------  Expr: Assignment  ----------*/
 
 func (expr *ExprAssignment) Eval(env *Env) Val {
 	val := expr.val.Eval(env)
-	env.Set(expr.name, val)
+	assignVariable(env, expr.depth, expr.slot, expr.name, val)
 	return val
 }
 
@@ -114,6 +301,16 @@ func (expr *ExprLiteral) Eval(env *Env) Val {
 	return expr.value
 }
 
+/*----------  Expr: String Interpolation  ----------*/
+
+func (expr *ExprInterpolation) Eval(env *Env) Val {
+	buf := &strings.Builder{}
+	for _, part := range expr.parts {
+		buf.WriteString(stringify(part.Eval(env)))
+	}
+	return buf.String()
+}
+
 /*----------  Expr: Unary  ----------*/
 
 func (expr *ExprUnary) Eval(env *Env) Val {
@@ -122,7 +319,16 @@ func (expr *ExprUnary) Eval(env *Env) Val {
 	case scanner.BANG:
 		return !getTruthy(value)
 	case scanner.MINUS:
-		return -(value.(scanner.Number))
+		if !isNumber(value) {
+			panic(NewRuntimeError(expr.operator, "operand must be a number"))
+		}
+		return -toNumber(value)
+	case scanner.TILDE:
+		n, ok := toInteger(value)
+		if !ok {
+			panic(NewRuntimeError(expr.operator, "operand must be an integer"))
+		}
+		return scanner.Number(^n)
 	}
 
 	// unreachable
@@ -133,15 +339,29 @@ func (expr *ExprUnary) Eval(env *Env) Val {
 func (expr *ExprBinary) Eval(env *Env) Val {
 	left := expr.left.Eval(env)
 	right := expr.right.Eval(env)
+	return binaryOperation(expr.operator.Type, expr.operator, left, right)
+}
 
+// apply binary operator typ, errors are reported at operator,
+// which can be a compound assignment operator like `+=`
+func binaryOperation(typ scanner.TokenType, operator *scanner.Token, left, right Val) Val {
 	checkNumberOperands := func() {
 		if isNumber(left) && isNumber(right) {
 			return
 		}
-		panic(NewRuntimeError(expr.operator, "operands must be numbers"))
+		panic(NewRuntimeError(operator, "operands must be numbers"))
 	}
 
-	switch expr.operator.Type {
+	integerOperands := func() (int64, int64) {
+		l, lok := toInteger(left)
+		r, rok := toInteger(right)
+		if lok && rok {
+			return l, r
+		}
+		panic(NewRuntimeError(operator, "operands must be integers"))
+	}
+
+	switch typ {
 	case scanner.PLUS:
 		if isNumber(left) && isNumber(right) {
 			return toNumber(left) + toNumber(right)
@@ -149,7 +369,7 @@ func (expr *ExprBinary) Eval(env *Env) Val {
 		if isString(left) && isString(right) {
 			return toString(left) + toString(right)
 		}
-		panic(NewRuntimeError(expr.operator, "operands must be two numbers or two strings"))
+		panic(NewRuntimeError(operator, "operands must be two numbers or two strings"))
 	case scanner.MINUS:
 		checkNumberOperands()
 		return toNumber(left) - toNumber(right)
@@ -158,12 +378,53 @@ func (expr *ExprBinary) Eval(env *Env) Val {
 		// catch divide by zero
 		r := toNumber(right)
 		if r == 0 {
-			panic(NewRuntimeError(expr.operator, "divide by zero"))
+			panic(NewRuntimeError(operator, "divide by zero"))
 		}
 		return toNumber(left) / r
+	case scanner.TILDE_SLASH:
+		checkNumberOperands()
+		r := toNumber(right)
+		if r == 0 {
+			panic(NewRuntimeError(operator, "divide by zero"))
+		}
+		return math.Floor(toNumber(left) / r)
+	case scanner.PERCENT:
+		checkNumberOperands()
+		// result has the same sign as the divisor, so -1 % 12 is 11
+		r := toNumber(right)
+		if r == 0 {
+			panic(NewRuntimeError(operator, "modulo by zero"))
+		}
+		m := math.Mod(toNumber(left), r)
+		if m != 0 && (m < 0) != (r < 0) {
+			m += r
+		}
+		return m
 	case scanner.STAR:
 		checkNumberOperands()
 		return toNumber(left) * toNumber(right)
+	case scanner.STAR_STAR:
+		checkNumberOperands()
+		return math.Pow(toNumber(left), toNumber(right))
+	case scanner.AMPERSAND:
+		l, r := integerOperands()
+		return scanner.Number(l & r)
+	case scanner.PIPE:
+		l, r := integerOperands()
+		return scanner.Number(l | r)
+	case scanner.CARET:
+		l, r := integerOperands()
+		return scanner.Number(l ^ r)
+	case scanner.LESS_LESS, scanner.GREATER_GREATER:
+		l, r := integerOperands()
+		if r < 0 {
+			panic(NewRuntimeError(operator, "negative shift count"))
+		}
+		if typ == scanner.LESS_LESS {
+			return scanner.Number(l << uint64(r))
+		}
+		// arithmetic shift, sign is kept
+		return scanner.Number(l >> uint64(r))
 	case scanner.GREATER:
 		checkNumberOperands()
 		return toNumber(left) > toNumber(right)
@@ -186,6 +447,44 @@ func (expr *ExprBinary) Eval(env *Env) Val {
 	panic("should neven reach here")
 }
 
+/*----------  Expr: Compound Assignment  ----------*/
+
+var compoundOperators = map[scanner.TokenType]scanner.TokenType{
+	scanner.PLUS_EQUAL:    scanner.PLUS,
+	scanner.MINUS_EQUAL:   scanner.MINUS,
+	scanner.STAR_EQUAL:    scanner.STAR,
+	scanner.SLASH_EQUAL:   scanner.SLASH,
+	scanner.PERCENT_EQUAL: scanner.PERCENT,
+}
+
+func (expr *ExprCompoundAssign) Eval(env *Env) Val {
+	left := lookUpVariable(env, expr.depth, expr.slot, expr.name)
+	right := expr.val.Eval(env)
+	val := binaryOperation(compoundOperators[expr.operator.Type], expr.operator, left, right)
+	assignVariable(env, expr.depth, expr.slot, expr.name, val)
+	return val
+}
+
+/*----------  Expr: Increment  ----------*/
+
+func (expr *ExprIncrement) Eval(env *Env) Val {
+	old := lookUpVariable(env, expr.depth, expr.slot, expr.name)
+	if !isNumber(old) {
+		panic(NewRuntimeError(expr.operator, "operand must be a number"))
+	}
+
+	val := toNumber(old) + 1
+	if expr.operator.Type == scanner.MINUS_MINUS {
+		val = toNumber(old) - 1
+	}
+	assignVariable(env, expr.depth, expr.slot, expr.name, val)
+
+	if expr.prefix {
+		return val
+	}
+	return old
+}
+
 /*----------  Expr: Grouping  ----------*/
 
 func (expr *ExprGrouping) Eval(env *Env) Val {
@@ -195,7 +494,7 @@ func (expr *ExprGrouping) Eval(env *Env) Val {
 /*----------  Expr: Variable  ----------*/
 
 func (expr *ExprVariable) Eval(env *Env) Val {
-	return env.Get(expr.name)
+	return lookUpVariable(env, expr.depth, expr.slot, expr.name)
 }
 
 /*----------  Expr: Logical  ----------*/
@@ -214,6 +513,22 @@ func (expr *ExprLogical) Eval(env *Env) Val {
 	return expr.right.Eval(env)
 }
 
+/*----------  Expr: Ternary  ----------*/
+
+func (expr *ExprTernary) Eval(env *Env) Val {
+	if getTruthy(expr.condition.Eval(env)) {
+		return expr.thenBranch.Eval(env)
+	}
+	return expr.elseBranch.Eval(env)
+}
+
+/*----------  Expr: Comma  ----------*/
+
+func (expr *ExprComma) Eval(env *Env) Val {
+	expr.left.Eval(env)
+	return expr.right.Eval(env)
+}
+
 /*----------  Expr: Function Call  ----------*/
 
 func (expr *ExprCall) Eval(env *Env) Val {
@@ -228,14 +543,152 @@ func (expr *ExprCall) Eval(env *Env) Val {
 		if expected != got {
 			panic(NewRuntimeError(expr.paren, fmt.Sprintf("expect %d arguments but got %d", expected, got)))
 		}
-		return function.Call(env, arguments)
+		return function.Call(env, expr.paren, arguments)
 	} else {
 		panic(NewRuntimeError(expr.paren, "can only call functions and classes"))
 	}
 }
 
+/*----------  Expr: Anonymous Function  ----------*/
+
+func (expr *ExprFunction) Eval(env *Env) Val {
+	return NewLoxFunction(expr.declaration, env, false)
+}
+
+/*----------  Expr: Property Get  ----------*/
+
+func (expr *ExprGet) Eval(env *Env) Val {
+	object := expr.object.Eval(env)
+	switch obj := object.(type) {
+	case *LoxInstance:
+		return obj.Get(expr.name)
+	case *LoxError:
+		return obj.Get(expr.name)
+	case *LoxModule:
+		return obj.Get(expr.name)
+	}
+	panic(NewRuntimeError(expr.name, "only instances have properties"))
+}
+
+/*----------  Expr: Property Set  ----------*/
+
+func (expr *ExprSet) Eval(env *Env) Val {
+	object := expr.object.Eval(env)
+	instance, ok := object.(*LoxInstance)
+	if !ok {
+		panic(NewRuntimeError(expr.name, "only instances have fields"))
+	}
+	val := expr.val.Eval(env)
+	instance.Set(expr.name, val)
+	return val
+}
+
+/*----------  Expr: This  ----------*/
+
+func (expr *ExprThis) Eval(env *Env) Val {
+	return env.GetAt(expr.depth, 0, expr.keyword)
+}
+
+/*----------  Expr: Super  ----------*/
+
+// `this` is always bound in the env right inside the one holding `super`
+func (expr *ExprSuper) Eval(env *Env) Val {
+	superclass := env.GetAt(expr.depth, 0, expr.keyword).(*LoxClass)
+	instance := env.ancestor(expr.depth - 1).slots[0].(*LoxInstance)
+	method := superclass.FindMethod(expr.method.Lexeme)
+	if method == nil {
+		panic(NewRuntimeError(expr.method, sprintf("undefined property '%s'", expr.method.Lexeme)))
+	}
+	return method.Bind(instance)
+}
+
+/*----------  Expr: List Literal  ----------*/
+
+func (expr *ExprList) Eval(env *Env) Val {
+	elements := make([]Val, len(expr.elements))
+	for i, element := range expr.elements {
+		elements[i] = element.Eval(env)
+	}
+	return NewLoxList(elements)
+}
+
+/*----------  Expr: Map Literal  ----------*/
+
+func (expr *ExprMap) Eval(env *Env) Val {
+	m := NewLoxMap()
+	for i, key := range expr.keys {
+		m.Set(expr.brace, key.Eval(env), expr.values[i].Eval(env))
+	}
+	return m
+}
+
+/*----------  Expr: Index Get  ----------*/
+
+func (expr *ExprIndex) Eval(env *Env) Val {
+	object := expr.object.Eval(env)
+	index := expr.index.Eval(env)
+	switch obj := object.(type) {
+	case *LoxList:
+		return obj.Get(expr.bracket, index)
+	case *LoxMap:
+		return obj.Get(expr.bracket, index)
+	}
+	panic(NewRuntimeError(expr.bracket, "only lists and maps can be indexed"))
+}
+
+/*----------  Expr: Index Set  ----------*/
+
+func (expr *ExprIndexSet) Eval(env *Env) Val {
+	object := expr.object.Eval(env)
+	index := expr.index.Eval(env)
+	switch obj := object.(type) {
+	case *LoxList:
+		val := expr.val.Eval(env)
+		obj.Set(expr.bracket, index, val)
+		return val
+	case *LoxMap:
+		val := expr.val.Eval(env)
+		obj.Set(expr.bracket, index, val)
+		return val
+	}
+	panic(NewRuntimeError(expr.bracket, "only lists and maps can be indexed"))
+}
+
+/*----------  Expr: Slice  ----------*/
+
+func (expr *ExprSlice) Eval(env *Env) Val {
+	object := expr.object.Eval(env)
+	var start, end Val
+	if expr.start != nil {
+		start = expr.start.Eval(env)
+	}
+	if expr.end != nil {
+		end = expr.end.Eval(env)
+	}
+	if list, ok := object.(*LoxList); ok {
+		return list.Slice(expr.bracket, start, end)
+	}
+	panic(NewRuntimeError(expr.bracket, "only lists can be sliced"))
+}
+
 /*----------  Helper Methods  ----------*/
 
+// depth and slot are computed by resolver, depth -1 means global variable
+func lookUpVariable(env *Env, depth int, slot int, name *scanner.Token) Val {
+	if depth < 0 {
+		return env.global().Get(name)
+	}
+	return env.GetAt(depth, slot, name)
+}
+
+func assignVariable(env *Env, depth int, slot int, name *scanner.Token, val Val) {
+	if depth < 0 {
+		env.global().Set(name, val)
+	} else {
+		env.SetAt(depth, slot, name, val)
+	}
+}
+
 // `false` and `nil` is false
 // everything else is true
 func getTruthy(val Val) bool {
@@ -248,6 +701,36 @@ func getTruthy(val Val) bool {
 	return true
 }
 
+// how `print` shows a value
+func stringify(val Val) string {
+	return fmt.Sprint(val)
+}
+
+// like stringify, but strings are quoted, used for elements of containers
+func reprValue(val Val) string {
+	return repr(val, map[Val]bool{})
+}
+
+// visited holds the containers being printed, a container which contains
+// itself is shown as `[...]` or `{...}` instead of recursing forever
+func repr(val Val, visited map[Val]bool) string {
+	switch v := val.(type) {
+	case string:
+		return strconv.Quote(v)
+	case *LoxList:
+		if visited[v] {
+			return "[...]"
+		}
+		return v.repr(visited)
+	case *LoxMap:
+		if visited[v] {
+			return "{...}"
+		}
+		return v.repr(visited)
+	}
+	return stringify(val)
+}
+
 func isNumber(val Val) bool {
 	_, ok := val.(scanner.Number)
 	return ok
@@ -267,6 +750,15 @@ func toNumber(val Val) scanner.Number {
 	panic("toNumber should always be called with a number")
 }
 
+// bitwise operators work on integral numbers as 64-bit signed integers
+func toInteger(val Val) (int64, bool) {
+	n, ok := val.(scanner.Number)
+	if !ok || n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(n), true
+}
+
 func toString(val Val) string {
 	if s, ok := val.(string); ok {
 		return s
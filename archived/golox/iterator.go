@@ -0,0 +1,116 @@
+package main
+
+import (
+	"cjting.me/lox/scanner"
+)
+
+/*----------  Iteration Protocol  ----------*/
+
+// values which can be used in `for (var x in iterable)`
+type Iterable interface {
+	Iterator() Iterator
+}
+
+type Iterator interface {
+	// return false when there are no more values
+	Next() (Val, bool)
+}
+
+// get an iterator of val, token is used to report runtime errors
+func iterate(token *scanner.Token, val Val) Iterator {
+	switch v := val.(type) {
+	case Iterable:
+		return v.Iterator()
+	case string:
+		return &stringIterator{[]rune(v), 0}
+	}
+	panic(NewRuntimeError(token, "can only iterate over lists, maps, strings and ranges"))
+}
+
+// lists are iterated by index, elements pushed during the loop are visited
+type listIterator struct {
+	list  *LoxList
+	index int
+}
+
+func (l *LoxList) Iterator() Iterator {
+	return &listIterator{l, 0}
+}
+
+func (it *listIterator) Next() (Val, bool) {
+	if it.index >= len(it.list.elements) {
+		return nil, false
+	}
+	it.index++
+	return it.list.elements[it.index-1], true
+}
+
+// maps are iterated by keys in insertion order,
+// keys are copied before the loop starts
+func (m *LoxMap) Iterator() Iterator {
+	keys := make([]Val, len(m.keys))
+	copy(keys, m.keys)
+	return &listIterator{NewLoxList(keys), 0}
+}
+
+// strings are iterated by characters
+type stringIterator struct {
+	runes []rune
+	index int
+}
+
+func (it *stringIterator) Next() (Val, bool) {
+	if it.index >= len(it.runes) {
+		return nil, false
+	}
+	it.index++
+	return string(it.runes[it.index-1]), true
+}
+
+/*----------  Range  ----------*/
+
+// LoxRange is numbers from start to end (exclusive) by step,
+// step can be negative
+type LoxRange struct {
+	start, end, step scanner.Number
+}
+
+func NewLoxRange(start, end, step scanner.Number) *LoxRange {
+	return &LoxRange{start, end, step}
+}
+
+func (r *LoxRange) String() string {
+	return sprintf("range(%v, %v, %v)", r.start, r.end, r.step)
+}
+
+type rangeIterator struct {
+	r       *LoxRange
+	current scanner.Number
+}
+
+func (r *LoxRange) Iterator() Iterator {
+	return &rangeIterator{r, r.start}
+}
+
+func (it *rangeIterator) Next() (Val, bool) {
+	if it.r.step > 0 && it.current >= it.r.end || it.r.step < 0 && it.current <= it.r.end {
+		return nil, false
+	}
+	val := it.current
+	it.current += it.r.step
+	return val, true
+}
+
+// range(start, end, step)
+func nativeRange(_ *Env, paren *scanner.Token, arguments []Val) Val {
+	for _, arg := range arguments {
+		if !isNumber(arg) {
+			panic(NewRuntimeError(paren, "range() expects numbers as arguments"))
+		}
+	}
+	step := toNumber(arguments[2])
+	if step == 0 {
+		panic(NewRuntimeError(paren, "range() step can't be zero"))
+	}
+	return NewLoxRange(toNumber(arguments[0]), toNumber(arguments[1]), step)
+}
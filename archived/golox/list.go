@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"math"
+
+	"cjting.me/lox/scanner"
+)
+
+/*----------  Lox List  ----------*/
+
+type LoxList struct {
+	elements []Val
+}
+
+func NewLoxList(elements []Val) *LoxList {
+	return &LoxList{elements}
+}
+
+func (l *LoxList) String() string {
+	return l.repr(map[Val]bool{})
+}
+
+func (l *LoxList) repr(visited map[Val]bool) string {
+	visited[l] = true
+	defer delete(visited, l)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("[")
+	for i, element := range l.elements {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(repr(element, visited))
+	}
+	buf.WriteString("]")
+	return buf.String()
+}
+
+// negative index counts from the end
+func (l *LoxList) Get(token *scanner.Token, index Val) Val {
+	return l.elements[l.index(token, index, len(l.elements)-1)]
+}
+
+func (l *LoxList) Set(token *scanner.Token, index Val, val Val) {
+	l.elements[l.index(token, index, len(l.elements)-1)] = val
+}
+
+// return a new list, start and end can be nil, which means
+// beginning and end of the list
+func (l *LoxList) Slice(token *scanner.Token, start, end Val) *LoxList {
+	length := len(l.elements)
+	from, to := 0, length
+	if start != nil {
+		from = l.index(token, start, length)
+	}
+	if end != nil {
+		to = l.index(token, end, length)
+	}
+	if from > to {
+		panic(NewRuntimeError(token, sprintf("invalid slice, start %d is greater than end %d", from, to)))
+	}
+
+	elements := make([]Val, to-from)
+	copy(elements, l.elements[from:to])
+	return NewLoxList(elements)
+}
+
+// convert a Lox index to a Go index which must be in [0, max]
+func (l *LoxList) index(token *scanner.Token, index Val, max int) int {
+	n, ok := index.(scanner.Number)
+	if !ok || n != math.Trunc(n) {
+		panic(NewRuntimeError(token, "list index must be an integer"))
+	}
+
+	// compare before converting, int() of a huge number is meaningless
+	i := n
+	if i < 0 {
+		i += scanner.Number(len(l.elements))
+	}
+	if i < 0 || i > scanner.Number(max) {
+		panic(NewRuntimeError(token, sprintf("list index %s out of range", stringify(n))))
+	}
+	return int(i)
+}
+
+/*----------  Native Functions  ----------*/
+
+// len(list, map or string)
+func nativeLen(_ *Env, paren *scanner.Token, arguments []Val) Val {
+	switch val := arguments[0].(type) {
+	case *LoxList:
+		return scanner.Number(len(val.elements))
+	case *LoxMap:
+		return scanner.Number(len(val.keys))
+	case string:
+		return scanner.Number(len([]rune(val)))
+	}
+	panic(NewRuntimeError(paren, "len() expects a list, a map or a string"))
+}
+
+// push(list, value), append value to the end of list
+func nativePush(_ *Env, paren *scanner.Token, arguments []Val) Val {
+	list := expectList(paren, "push", arguments[0])
+	list.elements = append(list.elements, arguments[1])
+	return nil
+}
+
+// pop(list), remove and return the last element
+func nativePop(_ *Env, paren *scanner.Token, arguments []Val) Val {
+	list := expectList(paren, "pop", arguments[0])
+	if len(list.elements) == 0 {
+		panic(NewRuntimeError(paren, "pop from empty list"))
+	}
+	last := list.elements[len(list.elements)-1]
+	list.elements = list.elements[:len(list.elements)-1]
+	return last
+}
+
+// insert(list, index, value), insert value before index,
+// index can be the length of list, which means append
+func nativeInsert(_ *Env, paren *scanner.Token, arguments []Val) Val {
+	list := expectList(paren, "insert", arguments[0])
+	i := list.index(paren, arguments[1], len(list.elements))
+	list.elements = append(list.elements, nil)
+	copy(list.elements[i+1:], list.elements[i:])
+	list.elements[i] = arguments[2]
+	return nil
+}
+
+// remove(list, index), remove and return the element at index
+func nativeRemove(_ *Env, paren *scanner.Token, arguments []Val) Val {
+	list := expectList(paren, "remove", arguments[0])
+	i := list.index(paren, arguments[1], len(list.elements)-1)
+	removed := list.elements[i]
+	list.elements = append(list.elements[:i], list.elements[i+1:]...)
+	return removed
+}
+
+func expectList(paren *scanner.Token, name string, val Val) *LoxList {
+	if list, ok := val.(*LoxList); ok {
+		return list
+	}
+	panic(NewRuntimeError(paren, name+"() expects a list as the first argument"))
+}
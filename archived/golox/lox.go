@@ -2,46 +2,87 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"cjting.me/lox/scanner"
 )
 
+// deep enough for legal recursion, small enough for Go stack
+const defaultMaxCallDepth = 10000
+
+// Engine is how programs are run
+type Engine int
+
+const (
+	// walk the AST, the default
+	EngineTree Engine = iota
+	// compile to bytecode and run it on VM
+	EngineVM
+)
+
 type Lox struct {
-	env    *Env
-	parser *Parser
+	engine Engine
+	// run optimizer before execution
+	optimize bool
+	// calls of Lox functions in progress, more than maxCallDepth
+	// is a stack overflow
+	callDepth    int
+	maxCallDepth int
+	// the module which REPL or the script runs in
+	main     *LoxModule
+	env      *Env
+	parser   *Parser
+	resolver *Resolver
+	// imported modules by absolute path
+	modules map[string]*LoxModule
+	// modules being run, used to detect circular import
+	importStack []*LoxModule
 }
 
 /*----------  Public API  ----------*/
 
 func NewLox() *Lox {
-	return &Lox{
-		env:    globalEnv,
-		parser: NewParser(),
+	lox := &Lox{
+		parser:   NewParser(),
+		resolver: NewResolver(),
+		modules:  map[string]*LoxModule{},
+
+		maxCallDepth: defaultMaxCallDepth,
 	}
+	lox.main = NewLoxModule(lox, "")
+	lox.env = lox.main.env
+	return lox
 }
 
-func (lox *Lox) Eval(source string) error {
-	// scan
-	tokens, err := scanner.Scan(source)
+func (lox *Lox) SetEngine(engine Engine) {
+	lox.engine = engine
+}
 
-	if err != nil {
-		return fmt.Errorf("scan error: %v", err)
-	}
+func (lox *Lox) SetOptimize(optimize bool) {
+	lox.optimize = optimize
+}
 
-	// parse
-	program, err := lox.parser.Parse(tokens)
-	if err != nil {
-		return fmt.Errorf("parse error: %v", err)
-	}
+func (lox *Lox) SetMaxCallDepth(depth int) {
+	lox.maxCallDepth = depth
+}
 
-	if err := lox.interpret(program); err != nil {
-		return fmt.Errorf("runtime error: %v", err)
-	}
+func (lox *Lox) Eval(source string) error {
+	return lox.runModule(lox.main, source)
+}
 
-	return nil
+// source is the content of the script at path, it runs as main module,
+// modules imported by it are resolved against its directory
+func (lox *Lox) EvalFile(path string, source string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	lox.main.path = abs
+	return lox.Eval(source)
 }
 
 func (lox *Lox) REPL() {
@@ -89,26 +130,52 @@ func (lox *Lox) evalExpression(source string) (val Val, err error) {
 		}
 	}()
 	expr := lox.parser.Expression()
-	if lox.parser.isAtEnd() {
-		val = expr.Eval(lox.env)
-	} else {
+	if len(lox.parser.errors) > 0 {
+		err = fmt.Errorf("parse error: %v", lox.parser.errors[0])
+	} else if !lox.parser.isAtEnd() {
 		err = fmt.Errorf("not a expression")
+	} else if e := lox.resolver.Resolve([]Stmt{NewStmtExpression(expr)}); e != nil {
+		err = prefixLines("resolve error: ", e)
+	} else if lox.engine == EngineVM {
+		val, err = lox.evalExpressionVM(expr)
+	} else {
+		val = expr.Eval(lox.env)
 	}
 	return
 }
 
-func (lox *Lox) interpret(program []Stmt) (err error) {
+func (lox *Lox) evalExpressionVM(expr Expr) (Val, error) {
+	function, err := CompileExpression(lox.main, expr)
+	if err != nil {
+		return nil, prefixLines("compile error: ", err)
+	}
+	return NewVM(lox.maxCallDepth).Interpret(function)
+}
+
+// errors like ParseErrors have one error per line
+func prefixLines(prefix string, err error) error {
+	lines := strings.Split(err.Error(), "\n")
+	for i := range lines {
+		lines[i] = prefix + lines[i]
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+func (lox *Lox) interpret(env *Env, program []Stmt) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			if re, ok := e.(*RuntimeError); ok {
+			switch re := e.(type) {
+			case *RuntimeError:
 				err = re
-			} else {
+			case *Throw:
+				err = re
+			default:
 				panic(e)
 			}
 		}
 	}()
 	for _, stmt := range program {
-		stmt.Run(lox.env)
+		stmt.Run(env)
 	}
 	return
 }
@@ -0,0 +1,1044 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cjting.me/lox/scanner"
+	"github.com/stretchr/testify/assert"
+)
+
+// run source with a fresh interpreter, return what it prints
+func runLox(source string) (string, error) {
+	buf := &bytes.Buffer{}
+	prev := stdout
+	stdout = buf
+	defer func() { stdout = prev }()
+
+	err := NewLox().Eval(source)
+	return buf.String(), err
+}
+
+func TestLoxClosure(t *testing.T) {
+	t.Run("bind lexically", func(t *testing.T) {
+		out, err := runLox(`
+var a = "global";
+{
+  func showA() {
+    print a;
+  }
+
+  showA();
+  var a = "block";
+  showA();
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "global\nglobal\n", out)
+	})
+
+	t.Run("counter", func(t *testing.T) {
+		out, err := runLox(`
+func makeCounter() {
+  var i = 0;
+  func count() {
+    i = i + 1;
+    print i;
+  }
+  return count;
+}
+var counter = makeCounter();
+counter();
+counter();
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "1\n2\n", out)
+	})
+
+	t.Run("each declaration makes a new closure", func(t *testing.T) {
+		out, err := runLox(`
+func makeAdder(n) {
+  func add(x) {
+    return x + n;
+  }
+  return add;
+}
+var add1 = makeAdder(1);
+var add2 = makeAdder(2);
+print add1(10);
+print add2(10);
+print add1;
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "11\n12\n<fn add>\n", out)
+	})
+}
+
+func TestLoxRunProgramTwice(t *testing.T) {
+	tokens, _ := scanner.Scan(`
+func makeCounter() {
+  var i = 0;
+  func count() {
+    i = i + 1;
+    return i;
+  }
+  return count;
+}
+var counter = makeCounter();
+counter();
+print counter();
+`)
+	program, err := NewParser().Parse(tokens)
+	assert.Nil(t, err)
+	assert.Nil(t, NewResolver().Resolve(program))
+
+	buf := &bytes.Buffer{}
+	stdout = buf
+	defer func() { stdout = os.Stdout }()
+
+	for i := 0; i < 2; i++ {
+		lox := NewLox()
+		assert.Nil(t, lox.interpret(lox.env, program))
+	}
+	assert.Equal(t, "2\n2\n", buf.String())
+}
+
+func TestLoxClass(t *testing.T) {
+	t.Run("fields and methods", func(t *testing.T) {
+		out, err := runLox(`
+class Point {
+  init(x, y) {
+    this.x = x;
+    this.y = y;
+  }
+  sum() {
+    return this.x + this.y;
+  }
+}
+var p = Point(1, 2);
+print p.sum();
+p.x = 10;
+var sum = p.sum;
+print sum();
+print p;
+print Point;
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "3\n12\nPoint instance\nPoint\n", out)
+	})
+
+	t.Run("init returns instance", func(t *testing.T) {
+		out, err := runLox(`
+class A {
+  init() {
+    this.v = 1;
+    return;
+  }
+}
+var a = A();
+print a.init() == a;
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "true\n", out)
+	})
+
+	t.Run("inheritance", func(t *testing.T) {
+		out, err := runLox(`
+class A {
+  name() { return "A"; }
+  hello() { return "hello " + this.name(); }
+}
+class B < A {
+  name() { return "B"; }
+  hello() { return super.hello() + "!"; }
+}
+print B().hello();
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "hello B!\n", out)
+	})
+
+	t.Run("runtime errors", func(t *testing.T) {
+		_, err := runLox("class A {}\nprint A().missing;")
+		assert.EqualError(t, err, "runtime error: line 2, undefined property 'missing'")
+
+		_, err = runLox("var NotClass = 1;\nclass B < NotClass {}")
+		assert.EqualError(t, err, "runtime error: line 2, superclass must be a class")
+
+		_, err = runLox("var a = 1;\na.b = 2;")
+		assert.EqualError(t, err, "runtime error: line 2, only instances have fields")
+	})
+}
+
+func TestLoxArithmetic(t *testing.T) {
+	out, err := runLox(`
+print 7 % 3;
+print -1 % 12;
+print 7 % -3;
+print 5.5 % 2;
+print 7 ~/ 2;
+print -7 ~/ 2;
+print 2 ** 10;
+print 2 ** 3 ** 2;
+print -2 ** 2;
+print 2 ** -1;
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, "1\n11\n-2\n1.5\n3\n-4\n1024\n512\n-4\n0.5\n", out)
+
+	_, err = runLox("print 1 % 0;")
+	assert.EqualError(t, err, "runtime error: line 1, modulo by zero")
+	_, err = runLox("print 1 ~/ 0;")
+	assert.EqualError(t, err, "runtime error: line 1, divide by zero")
+	_, err = runLox(`print 2 ** "a";`)
+	assert.EqualError(t, err, "runtime error: line 1, operands must be numbers")
+}
+
+func TestLoxBitwise(t *testing.T) {
+	out, err := runLox(`
+print 12 & 10;
+print 12 | 10;
+print 12 ^ 10;
+print ~5;
+print 1 << 10;
+print -16 >> 2;
+print (6 & 3) == 2;
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, "8\n14\n6\n-6\n1024\n-4\ntrue\n", out)
+
+	_, err = runLox("\nprint 1.5 | 1;")
+	assert.EqualError(t, err, "runtime error: line 2, operands must be integers")
+	_, err = runLox(`print ~"a";`)
+	assert.EqualError(t, err, "runtime error: line 1, operand must be an integer")
+	_, err = runLox("print 1 << -1;")
+	assert.EqualError(t, err, "runtime error: line 1, negative shift count")
+}
+
+func TestLoxCompoundAssignment(t *testing.T) {
+	out, err := runLox(`
+var a = 10;
+a += 5; print a;
+a -= 3; print a;
+a *= 2; print a;
+a /= 4; print a;
+a %= 4; print a;
+var s = "foo";
+s += "bar"; print s;
+
+var i = 0;
+print i++;
+print i;
+print ++i;
+print i--;
+print --i;
+
+var sum = 0;
+for (var j = 0; j < 5; j++) {
+  sum += j;
+}
+print sum;
+
+func counter() {
+  var n = 0;
+  return func() { return ++n; };
+}
+var c = counter();
+c();
+print c();
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, "15\n12\n24\n6\n2\nfoobar\n0\n1\n2\n2\n0\n10\n2\n", out)
+
+	_, err = runLox("b += 1;")
+	assert.EqualError(t, err, "runtime error: line 1, undefined variable 'b'")
+	_, err = runLox("c++;")
+	assert.EqualError(t, err, "runtime error: line 1, undefined variable 'c'")
+	_, err = runLox(`var s = "a"; s -= 1;`)
+	assert.EqualError(t, err, "runtime error: line 1, operands must be numbers")
+	_, err = runLox(`var s = "a"; s++;`)
+	assert.EqualError(t, err, "runtime error: line 1, operand must be a number")
+	_, err = runLox("{ var a = a++; }")
+	assert.EqualError(t, err, "resolve error: line 1, at 'a', can't read local variable in its own initializer")
+}
+
+func TestLoxBreakContinue(t *testing.T) {
+	t.Run("for loop", func(t *testing.T) {
+		out, err := runLox(`
+for (var i = 0; i < 10; i = i + 1) {
+  if (i == 1) continue;
+  if (i == 4) break;
+  print i;
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "0\n2\n3\n", out)
+	})
+
+	t.Run("while loop", func(t *testing.T) {
+		out, err := runLox(`
+var i = 0;
+while (true) {
+  i = i + 1;
+  if (i < 3) continue;
+  print i;
+  break;
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "3\n", out)
+	})
+
+	t.Run("nested loop", func(t *testing.T) {
+		out, err := runLox(`
+for (var i = 0; i < 2; i = i + 1) {
+  for (var j = 0; j < 10; j = j + 1) {
+    if (j == 1) break;
+    print i + j;
+  }
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "0\n1\n", out)
+	})
+
+	t.Run("outside of a loop", func(t *testing.T) {
+		_, err := runLox("break;\nwhile (true) { func f() { continue; } }")
+		assert.EqualError(t, err, "parse error: line 1, at 'break', can't use 'break' outside of a loop or switch\n"+
+			"parse error: line 2, at 'continue', can't use 'continue' outside of a loop")
+	})
+
+	t.Run("after an error in the loop body", func(t *testing.T) {
+		for _, source := range []string{
+			"while (true) print ;\nbreak;",
+			"for (var i = 0; i < 1; i = i + 1) print ;\nbreak;",
+			"for (var x in []) print ;\nbreak;",
+			"while (true) { func f() { print ; } }\nbreak;",
+		} {
+			_, err := runLox(source)
+			assert.EqualError(t, err, "parse error: line 1, at ';', expect expression\n"+
+				"parse error: line 2, at 'break', can't use 'break' outside of a loop or switch", source)
+		}
+	})
+}
+
+func TestLoxForIn(t *testing.T) {
+	t.Run("iterables", func(t *testing.T) {
+		out, err := runLox(`
+for (var x in [1, "two", true]) print x;
+for (var k in {"a": 1, "b": 2}) print k;
+for (var c in "hé") print c;
+for (var i in range(0, 3, 1)) print i;
+for (var i in range(3, 0, -2)) print i;
+for (var i in range(0, 0, 1)) print "empty";
+print range(0, 10, 2);
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "1\ntwo\ntrue\na\nb\nh\né\n0\n1\n2\n3\n1\nrange(0, 10, 2)\n", out)
+	})
+
+	t.Run("fresh binding per iteration", func(t *testing.T) {
+		out, err := runLox(`
+var fs = [];
+for (var i in range(0, 3, 1)) {
+  push(fs, func() { return i; });
+}
+for (var f in fs) print f();
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "0\n1\n2\n", out)
+	})
+
+	t.Run("break and continue", func(t *testing.T) {
+		out, err := runLox(`
+for (var i in range(0, 10, 1)) {
+  if (i == 1) continue;
+  if (i == 3) break;
+  print i;
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "0\n2\n", out)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := runLox("for (var x in 1) print x;")
+		assert.EqualError(t, err, "runtime error: line 1, can only iterate over lists, maps, strings and ranges")
+		_, err = runLox("range(0, 1, 0);")
+		assert.EqualError(t, err, "runtime error: line 1, range() step can't be zero")
+		_, err = runLox("{ var xs = []; for (var xs in xs) print xs; }")
+		assert.Nil(t, err)
+	})
+}
+
+func TestLoxSwitch(t *testing.T) {
+	t.Run("cases", func(t *testing.T) {
+		out, err := runLox(`
+func name(n) {
+  switch (n) {
+    default:
+      return "many";
+    case 0:
+      return "zero";
+    case 1, 2:
+      var s = "few";
+      return s;
+    case "one":
+      return "string";
+  }
+}
+print name(0);
+print name(2);
+print name("one");
+print name(3);
+print name(nil);
+
+switch (1) {
+  case 2:
+    print "no default";
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "zero\nfew\nstring\nmany\nmany\n", out)
+	})
+
+	t.Run("case values are evaluated until matched", func(t *testing.T) {
+		out, err := runLox(`
+func v(n) { print "eval ${n}"; return n; }
+switch (v(2)) {
+  case v(1), v(2), v(3):
+    print "matched";
+  case v(4):
+    print "unreachable";
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "eval 2\neval 1\neval 2\nmatched\n", out)
+	})
+
+	t.Run("break and continue", func(t *testing.T) {
+		out, err := runLox(`
+for (var i = 0; i < 4; i++) {
+  switch (i) {
+    case 1:
+      continue;
+    case 2:
+      break;
+      print "unreachable";
+  }
+  print i;
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "0\n2\n3\n", out)
+	})
+
+	t.Run("parse error", func(t *testing.T) {
+		_, err := runLox("switch (1) {\n default: print 1;\n default: print 2;\n}")
+		assert.EqualError(t, err, "parse error: line 3, at 'default', a switch can't have more than one 'default'")
+
+		_, err = runLox("switch (1) { print 1; }")
+		assert.Contains(t, err.Error(), "parse error: line 1, at 'print', expect 'case' or 'default' in switch body")
+
+		_, err = runLox("switch (1) { case 1: continue; }")
+		assert.EqualError(t, err, "parse error: line 1, at 'continue', can't use 'continue' outside of a loop")
+	})
+}
+
+func TestLoxList(t *testing.T) {
+	t.Run("literal index and slice", func(t *testing.T) {
+		out, err := runLox(`
+var xs = [1, "two", [3]];
+print xs;
+print xs[1];
+print xs[-1][0];
+xs[0] = 10;
+print xs[0];
+print [1, 2, 3, 4][1:3];
+print [1, 2, 3, 4][-2:];
+print [1, 2, 3, 4][:1];
+print [];
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "[1, \"two\", [3]]\ntwo\n3\n10\n[2, 3]\n[3, 4]\n[1]\n[]\n", out)
+	})
+
+	t.Run("natives", func(t *testing.T) {
+		out, err := runLox(`
+var xs = [];
+push(xs, 1);
+push(xs, 2);
+insert(xs, 0, 0);
+insert(xs, len(xs), 3);
+print xs;
+print pop(xs);
+print remove(xs, 1);
+print xs;
+print len(xs);
+print len("hello");
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "[0, 1, 2, 3]\n3\n1\n[0, 2]\n2\n5\n", out)
+	})
+
+	t.Run("self containing", func(t *testing.T) {
+		out, err := runLox(`
+var xs = [1];
+push(xs, xs);
+print xs;
+var ys = [xs, xs];
+print ys;
+print "${xs}";
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "[1, [...]]\n[[1, [...]], [1, [...]]]\n[1, [...]]\n", out)
+	})
+
+	t.Run("runtime errors", func(t *testing.T) {
+		tests := map[string]string{
+			"var xs = [1];\nprint xs[1];":             "runtime error: line 2, list index 1 out of range",
+			"var xs = [1];\nprint xs[-2];":            "runtime error: line 2, list index -2 out of range",
+			"var xs = [1];\nprint xs[2 ** 100];":      "runtime error: line 2, list index 1.2676506002282294e+30 out of range",
+			"var xs = [1];\nprint xs[1:2 ** 100];":    "runtime error: line 2, list index 1.2676506002282294e+30 out of range",
+			"var xs = [1];\ninsert(xs, 2 ** 100, 1);": "runtime error: line 2, list index 1.2676506002282294e+30 out of range",
+			"var xs = [1];\nxs[0.5] = 1;":             "runtime error: line 2, list index must be an integer",
+			"var xs = [1];\nprint xs[1:0];":           "runtime error: line 2, invalid slice, start 1 is greater than end 0",
+			"var a = 1;\nprint a[0];":                 "runtime error: line 2, only lists and maps can be indexed",
+			"\npop([]);":                              "runtime error: line 2, pop from empty list",
+			"\npush(1, 2);":                           "runtime error: line 2, push() expects a list as the first argument",
+		}
+		for source, msg := range tests {
+			_, err := runLox(source)
+			assert.EqualError(t, err, msg, source)
+		}
+	})
+}
+
+func TestLoxMap(t *testing.T) {
+	t.Run("literal and index", func(t *testing.T) {
+		out, err := runLox(`
+var m = {"b": 1, "a": 2, 3: "three", true: nil};
+print m;
+print m["a"];
+print m[3];
+m["c"] = [1];
+m["b"] = 10;
+print m;
+print len(m);
+print {};
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"b": 1, "a": 2, 3: "three", true: <nil>}
+2
+three
+{"b": 10, "a": 2, 3: "three", true: <nil>, "c": [1]}
+5
+{}
+`, out)
+	})
+
+	t.Run("statement starting with a map", func(t *testing.T) {
+		out, err := runLox(`
+func show(v) { print v; return v; }
+{"a": 1}["a"];
+{-1: show("negative")}[-1];
+{"a" + "b": show("concat")};
+{ print "block"; }
+{ show("call"); }
+{ var a; a = -1; show(a); }
+{ { print "nested"; } }
+{}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "negative\nconcat\nblock\ncall\n-1\nnested\n", out)
+	})
+
+	t.Run("natives", func(t *testing.T) {
+		out, err := runLox(`
+var m = {"x": 1, "y": 2, "z": 3};
+print keys(m);
+print values(m);
+print has(m, "y");
+print delete(m, "y");
+print delete(m, "y");
+print has(m, "y");
+print keys(m);
+m["y"] = 4;
+print keys(m);
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, `["x", "y", "z"]
+[1, 2, 3]
+true
+true
+false
+false
+["x", "z"]
+["x", "z", "y"]
+`, out)
+	})
+
+	t.Run("self containing", func(t *testing.T) {
+		out, err := runLox(`
+var m = {};
+m["self"] = m;
+m["list"] = [m];
+print m;
+print "${m}";
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "{\"self\": {...}, \"list\": [{...}]}\n{\"self\": {...}, \"list\": [{...}]}\n", out)
+	})
+
+	t.Run("runtime errors", func(t *testing.T) {
+		tests := map[string]string{
+			"var m = {};\nprint m[\"a\"];": "runtime error: line 2, undefined key \"a\"",
+			"var m = {};\nm[[]] = 1;":      "runtime error: line 2, map key must be a string, number or boolean",
+			"\nvar m = {nil: 1};":          "runtime error: line 2, map key must be a string, number or boolean",
+			"\nkeys([]);":                  "runtime error: line 2, keys() expects a map as the first argument",
+		}
+		for source, msg := range tests {
+			_, err := runLox(source)
+			assert.EqualError(t, err, msg, source)
+		}
+	})
+}
+
+func TestLoxInterpolation(t *testing.T) {
+	out, err := runLox(`
+var a = 1;
+var b = 2;
+print "total: ${a + b}";
+print "${a}${b}";
+print "list ${[1, "x"]} map ${ {"k": nil}["k"] } bool ${a < b}";
+print "nested ${"inner ${a + 1}"}!";
+func f() { return "fn"; }
+print "${f()} ${f}";
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, `total: 3
+12
+list [1, "x"] map <nil> bool true
+nested inner 2!
+fn <fn f>
+`, out)
+
+	tokens, _ := scanner.Scan(`"a ${b} c"`)
+	parser := NewParser()
+	parser.reset(tokens)
+	assert.Equal(t, `(interpolate "a " b " c")`, parser.Expression().Print())
+}
+
+func TestLoxAnonymousFunction(t *testing.T) {
+	out, err := runLox(`
+func apply(f, a, b) {
+  return f(a, b);
+}
+print apply(func (a, b) { return a + b; }, 1, 2);
+
+func makeAdder(n) {
+  return func (x) { return x + n; };
+}
+print makeAdder(10)(5);
+
+var double = func (x) { return x * 2; };
+print double(4);
+print double;
+
+func (x) { print x; }(7);
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, "3\n15\n8\n<fn anonymous>\n7\n", out)
+}
+
+func TestLoxREPLExpression(t *testing.T) {
+	lox := NewLox()
+	val, err := lox.evalExpression("(func (x) { return x; })(2)")
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, val)
+
+	_, err = lox.evalExpression("func () { return this; }")
+	assert.EqualError(t, err, "resolve error: line 1, at 'this', can't use 'this' outside of a class")
+}
+
+func TestLoxException(t *testing.T) {
+	t.Run("catch runtime error", func(t *testing.T) {
+		out, err := runLox(`
+try {
+  print 1 / 0;
+} catch (e) {
+  print e.message;
+  print e.line;
+  print e;
+}
+try {
+  print undefinedVariable;
+} catch (e) {
+  print e.message;
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "divide by zero\n3\nline 3, divide by zero\nundefined variable 'undefinedVariable'\n", out)
+	})
+
+	t.Run("throw and finally", func(t *testing.T) {
+		out, err := runLox(`
+func f(x) {
+  if (x) throw {"code": x};
+  return "ok";
+}
+try {
+  print f(nil);
+  print f(42);
+  print "unreachable";
+} catch (e) {
+  print e["code"];
+} finally {
+  print "finally";
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "ok\n42\nfinally\n", out)
+	})
+
+	t.Run("finally with return, break and continue", func(t *testing.T) {
+		out, err := runLox(`
+func f() {
+  try {
+    return "returned";
+  } finally {
+    print "cleanup";
+  }
+}
+print f();
+for (var i = 0; i < 3; i = i + 1) {
+  try {
+    if (i == 0) continue;
+    break;
+  } finally {
+    print i;
+  }
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "cleanup\nreturned\n0\n1\n", out)
+	})
+
+	t.Run("rethrow and nesting", func(t *testing.T) {
+		out, err := runLox(`
+try {
+  try {
+    throw "inner";
+  } catch (e) {
+    throw e + " rethrown";
+  } finally {
+    print "inner finally";
+  }
+} catch (e) {
+  print e;
+}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "inner finally\ninner rethrown\n", out)
+	})
+
+	t.Run("finally overrides", func(t *testing.T) {
+		out, err := runLox(`
+func f() {
+  try {
+    throw "lost";
+  } finally {
+    return "finally";
+  }
+}
+print f();
+
+func g() {
+  for (var i in range(0, 3, 1)) {
+    try {
+      return "try";
+    } finally {
+      continue;
+    }
+  }
+  return "loop";
+}
+print g();
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "finally\nloop\n", out)
+	})
+
+	t.Run("return through statements", func(t *testing.T) {
+		out, err := runLox(`
+func f(xs) {
+  for (var x in xs) {
+    switch (x) {
+      case 2:
+        try {
+          if (true) { return x * 10; }
+        } catch (e) {}
+    }
+  }
+  return -1;
+}
+print f([1, 2, 3]);
+print f([]);
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "20\n-1\n", out)
+	})
+
+	t.Run("uncaught", func(t *testing.T) {
+		out, err := runLox("try {\n  throw \"oops\";\n} finally {\n  print \"finally\";\n}")
+		assert.Equal(t, "finally\n", out)
+		assert.EqualError(t, err, "runtime error: line 2, uncaught exception: oops")
+
+		_, err = runLox("\nprint -\"a\";")
+		assert.EqualError(t, err, "runtime error: line 2, operand must be a number")
+	})
+
+	t.Run("parse error", func(t *testing.T) {
+		_, err := runLox("try {}")
+		assert.EqualError(t, err, "parse error: line 1, at 'try', expect 'catch' or 'finally' after try block")
+	})
+}
+
+func TestLoxModule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lox")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, source string) string {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(source), 0644))
+		return path
+	}
+
+	// run file with a fresh interpreter, return what it prints
+	runFile := func(path string) (string, error) {
+		buf := &bytes.Buffer{}
+		prev := stdout
+		stdout = buf
+		defer func() { stdout = prev }()
+
+		source, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		err = NewLox().EvalFile(path, string(source))
+		return buf.String(), err
+	}
+
+	t.Run("import", func(t *testing.T) {
+		write("lib/math.lox", `
+import "helper.lox" as helper;
+print "load math";
+var name = "math";
+func double(n) { return helper.add(n, n); }
+`)
+		write("lib/helper.lox", `
+var name = "helper";
+func add(a, b) { return a + b; }
+`)
+		out, err := runFile(write("import.lox", `
+import "lib/math.lox" as m;
+var name = "main";
+{
+  import "lib/math.lox" as again;
+  print m == again;
+}
+print m.double(21);
+print m.name;
+print name;
+`))
+		assert.Nil(t, err)
+		assert.Equal(t, "load math\ntrue\n42\nmath\nmain\n", out)
+	})
+
+	t.Run("missing member", func(t *testing.T) {
+		write("member.lox", "var a = 1;")
+		_, err := runFile(write("member_main.lox", "import \"member.lox\" as m;\nprint m.b;"))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "line 2, module '")
+		assert.Contains(t, err.Error(), "member.lox' has no member 'b'")
+	})
+
+	t.Run("circular import", func(t *testing.T) {
+		write("a.lox", `import "b.lox" as b;`)
+		write("b.lox", `import "a.lox" as a;`)
+		_, err := runFile(filepath.Join(dir, "a.lox"))
+		assert.Error(t, err)
+		assert.Regexp(t, `circular import: \S*a\.lox -> \S*b\.lox -> \S*a\.lox`, err.Error())
+	})
+
+	t.Run("error in module", func(t *testing.T) {
+		write("broken.lox", "\nvar a = ;")
+		_, err := runFile(write("broken_main.lox", `import "broken.lox" as m;`))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "broken.lox: parse error: line 2, at ';'")
+
+		_, err = runFile(write("missing_main.lox", `import "missing.lox" as m;`))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "line 1, could not import module")
+	})
+}
+
+func TestLoxStackOverflow(t *testing.T) {
+	t.Run("unbounded recursion", func(t *testing.T) {
+		out, err := runLox("print 1;\nfunc f(n) {\n  return f(n + 1);\n}\nf(0);")
+		assert.Equal(t, "1\n", out)
+		assert.EqualError(t, err, "runtime error: line 3, stack overflow")
+	})
+
+	t.Run("deep recursion", func(t *testing.T) {
+		out, err := runLox(`
+func sum(n) {
+  if (n == 0) return 0;
+  return n + sum(n - 1);
+}
+print sum(9000) == 40504500;
+
+class Node {
+  depth(n) { return n == 0 ? 0 : 1 + this.depth(n - 1); }
+}
+print Node().depth(9000);
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "true\n9000\n", out)
+	})
+
+	t.Run("catch and recurse again", func(t *testing.T) {
+		out, err := runLox(`
+func f() { f(); }
+func g(n) {
+  try {
+    f();
+  } catch (e) {
+    print e.message;
+  } finally {
+    if (n > 0) g(n - 1);
+  }
+}
+g(2);
+func deep(n) { return n == 0 ? "deep" : deep(n - 1); }
+print deep(9000);
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, "stack overflow\nstack overflow\nstack overflow\ndeep\n", out)
+	})
+
+	t.Run("configurable", func(t *testing.T) {
+		lox := NewLox()
+		lox.SetMaxCallDepth(10)
+		assert.Nil(t, lox.Eval("func f(n) { if (n > 0) f(n - 1); }\nf(9);"))
+		assert.EqualError(t, lox.Eval("f(10);"), "runtime error: line 1, stack overflow")
+		// depth is restored after the error
+		assert.Nil(t, lox.Eval("f(9);"))
+	})
+
+	t.Run("REPL expression", func(t *testing.T) {
+		lox := NewLox()
+		lox.SetMaxCallDepth(100)
+		assert.Nil(t, lox.Eval("func f(n) { return n == 0 ? nil + 1 : f(n - 1); }"))
+		for i := 0; i < 3; i++ {
+			_, err := lox.evalExpression("f(40)")
+			assert.EqualError(t, err, "line 1, operands must be two numbers or two strings")
+		}
+		assert.EqualError(t, lox.Eval("f(98);"), "runtime error: line 1, operands must be two numbers or two strings")
+	})
+}
+
+/*----------  Benchmarks  ----------*/
+
+func benchmarkLox(b *testing.B, source string) {
+	prev := stdout
+	stdout = ioutil.Discard
+	defer func() { stdout = prev }()
+
+	for i := 0; i < b.N; i++ {
+		if err := NewLox().Eval(source); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// deep recursion, every call returns with `return`
+func BenchmarkLoxFibonacci(b *testing.B) {
+	benchmarkLox(b, `
+func fib(n) {
+  if (n <= 1) return n;
+  return fib(n - 2) + fib(n - 1);
+}
+fib(20);
+`)
+}
+
+// early return from inside nested loops
+func BenchmarkLoxReturnFromLoop(b *testing.B) {
+	benchmarkLox(b, `
+func find(n) {
+  for (var i = 0; i < 100; i++) {
+    while (true) {
+      if (i == n) return i;
+      break;
+    }
+  }
+}
+for (var i = 0; i < 2000; i++) find(10);
+`)
+}
+
+// break and continue in a hot loop
+func BenchmarkLoxBreakContinue(b *testing.B) {
+	benchmarkLox(b, `
+var sum = 0;
+for (var i = 0; i < 20000; i++) {
+  if (i % 2 == 0) continue;
+  if (i > 19990) break;
+  sum += i;
+}
+`)
+}
+
+// closures created and called in a loop, captured variables are
+// read and written several scopes away
+func BenchmarkLoxClosure(b *testing.B) {
+	benchmarkLox(b, `
+func makeCounter() {
+  var count = 0;
+  func inc() {
+    count = count + 1;
+    return count;
+  }
+  return inc;
+}
+var total = 0;
+for (var i = 0; i < 500; i++) {
+  var counter = makeCounter();
+  for (var j = 0; j < 20; j++) {
+    total = total + counter();
+  }
+}
+`)
+}
+
+// nested loops over locals in blocks
+func BenchmarkLoxLoop(b *testing.B) {
+	benchmarkLox(b, `
+func sum(n) {
+  var result = 0;
+  for (var i = 0; i < n; i++) {
+    var square = i * i;
+    {
+      var half = square / 2;
+      result = result + half;
+    }
+  }
+  return result;
+}
+for (var k = 0; k < 10; k++) sum(2000);
+`)
+}
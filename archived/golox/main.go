@@ -10,9 +10,15 @@ import (
 
 var (
 	scriptPath string
+	engine     string
+	optimize   bool
+	maxDepth   int
 )
 
 func parseFlags() {
+	kingpin.Flag("engine", "how to run the program, tree or vm").Default("tree").EnumVar(&engine, "tree", "vm")
+	kingpin.Flag("optimize", "fold constant expressions and prune dead branches").Short('O').BoolVar(&optimize)
+	kingpin.Flag("max-call-depth", "nested calls allowed before stack overflow").Default("10000").IntVar(&maxDepth)
 	kingpin.Arg("script", "specify script path, if none, start REPL").StringVar(&scriptPath)
 	kingpin.CommandLine.HelpFlag.Short('h')
 	kingpin.Parse()
@@ -22,6 +28,11 @@ func main() {
 	parseFlags()
 
 	lox := NewLox()
+	if engine == "vm" {
+		lox.SetEngine(EngineVM)
+	}
+	lox.SetOptimize(optimize)
+	lox.SetMaxCallDepth(maxDepth)
 
 	if scriptPath == "" {
 		lox.REPL()
@@ -31,7 +42,7 @@ func main() {
 			fmt.Printf("could not open file: %v\n", err)
 			os.Exit(1)
 		}
-		if err := lox.Eval(string(buf)); err != nil {
+		if err := lox.EvalFile(scriptPath, string(buf)); err != nil {
 			fmt.Println(err)
 		}
 	}
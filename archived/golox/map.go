@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+
+	"cjting.me/lox/scanner"
+)
+
+/*----------  Lox Map  ----------*/
+
+// LoxMap iterates in insertion order, keys are compared with the same
+// equality as `==`
+type LoxMap struct {
+	keys   []Val
+	values map[Val]Val
+}
+
+func NewLoxMap() *LoxMap {
+	return &LoxMap{nil, map[Val]Val{}}
+}
+
+func (m *LoxMap) String() string {
+	return m.repr(map[Val]bool{})
+}
+
+func (m *LoxMap) repr(visited map[Val]bool) string {
+	visited[m] = true
+	defer delete(visited, m)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("{")
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(repr(key, visited))
+		buf.WriteString(": ")
+		buf.WriteString(repr(m.values[key], visited))
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+func (m *LoxMap) Get(token *scanner.Token, key Val) Val {
+	checkMapKey(token, key)
+	val, ok := m.values[key]
+	if !ok {
+		panic(NewRuntimeError(token, sprintf("undefined key %s", reprValue(key))))
+	}
+	return val
+}
+
+func (m *LoxMap) Set(token *scanner.Token, key Val, val Val) {
+	checkMapKey(token, key)
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = val
+}
+
+func (m *LoxMap) Has(key Val) bool {
+	_, ok := m.values[key]
+	return ok
+}
+
+// return whether key exists
+func (m *LoxMap) Delete(key Val) bool {
+	if !m.Has(key) {
+		return false
+	}
+	delete(m.values, key)
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func checkMapKey(token *scanner.Token, key Val) {
+	switch key.(type) {
+	case string, scanner.Number, bool:
+		return
+	}
+	panic(NewRuntimeError(token, "map key must be a string, number or boolean"))
+}
+
+/*----------  Native Functions  ----------*/
+
+// keys(map), return keys as a list in insertion order
+func nativeKeys(_ *Env, paren *scanner.Token, arguments []Val) Val {
+	m := expectMap(paren, "keys", arguments[0])
+	keys := make([]Val, len(m.keys))
+	copy(keys, m.keys)
+	return NewLoxList(keys)
+}
+
+// values(map), return values as a list in insertion order of keys
+func nativeValues(_ *Env, paren *scanner.Token, arguments []Val) Val {
+	m := expectMap(paren, "values", arguments[0])
+	values := make([]Val, len(m.keys))
+	for i, key := range m.keys {
+		values[i] = m.values[key]
+	}
+	return NewLoxList(values)
+}
+
+// has(map, key)
+func nativeHas(_ *Env, paren *scanner.Token, arguments []Val) Val {
+	m := expectMap(paren, "has", arguments[0])
+	return m.Has(arguments[1])
+}
+
+// delete(map, key), return whether key exists
+func nativeDelete(_ *Env, paren *scanner.Token, arguments []Val) Val {
+	m := expectMap(paren, "delete", arguments[0])
+	return m.Delete(arguments[1])
+}
+
+func expectMap(paren *scanner.Token, name string, val Val) *LoxMap {
+	if m, ok := val.(*LoxMap); ok {
+		return m
+	}
+	panic(NewRuntimeError(paren, name+"() expects a map as the first argument"))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cjting.me/lox/scanner"
+)
+
+// LoxModule is a source file with its own global env,
+// `import "path" as name;` binds name to the module
+type LoxModule struct {
+	// absolute path, empty for code not from a file, e.g. REPL
+	path string
+	env  *Env
+	lox  *Lox
+}
+
+func NewLoxModule(lox *Lox, path string) *LoxModule {
+	module := &LoxModule{path: path, lox: lox}
+	module.env = NewGlobalEnv(module)
+	return module
+}
+
+func (m *LoxModule) String() string {
+	return "<module " + m.name() + ">"
+}
+
+// path relative to working directory if it's inside, used in messages
+func (m *LoxModule) name() string {
+	if m.path == "" {
+		return "<script>"
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, m.path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return m.path
+}
+
+// relative imports are resolved against this directory
+func (m *LoxModule) dir() string {
+	if m.path == "" {
+		wd, _ := os.Getwd()
+		return wd
+	}
+	return filepath.Dir(m.path)
+}
+
+// top-level definitions of the module
+func (m *LoxModule) Get(name *scanner.Token) Val {
+	if val, ok := m.env.globals[name.Lexeme]; ok {
+		return val
+	}
+	panic(NewRuntimeError(name, sprintf("module '%s' has no member '%s'", m.name(), name.Lexeme)))
+}
+
+/*----------  Module Loading  ----------*/
+
+// load, parse and run the module at path once, later imports get the
+// cached module, path is the string token in import statement
+func (lox *Lox) importModule(importer *LoxModule, path *scanner.Token) *LoxModule {
+	target := path.Literal.(string)
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(importer.dir(), target)
+	}
+	target = filepath.Clean(target)
+
+	for i, loading := range lox.importStack {
+		if loading.path == target {
+			var names []string
+			for _, m := range lox.importStack[i:] {
+				names = append(names, m.name())
+			}
+			names = append(names, loading.name())
+			panic(NewRuntimeError(path, "circular import: "+strings.Join(names, " -> ")))
+		}
+	}
+
+	if module, ok := lox.modules[target]; ok {
+		return module
+	}
+
+	buf, err := ioutil.ReadFile(target)
+	if err != nil {
+		panic(NewRuntimeError(path, sprintf("could not import module: %v", err)))
+	}
+
+	module := NewLoxModule(lox, target)
+	if err := lox.runModule(module, string(buf)); err != nil {
+		panic(NewRuntimeError(path, sprintf("error in module '%s'\n%v", module.name(), prefixLines(module.name()+": ", err))))
+	}
+	lox.modules[target] = module
+
+	return module
+}
+
+// run source as the content of module, imports happen at run time,
+// after parser and resolver finish with the importing module
+func (lox *Lox) runModule(module *LoxModule, source string) error {
+	lox.importStack = append(lox.importStack, module)
+	defer func() {
+		lox.importStack = lox.importStack[:len(lox.importStack)-1]
+	}()
+
+	// scan
+	tokens, err := scanner.Scan(source)
+	if err != nil {
+		return fmt.Errorf("scan error: %v", err)
+	}
+
+	// parse
+	program, err := lox.parser.Parse(tokens)
+	if err != nil {
+		return prefixLines("parse error: ", err)
+	}
+
+	// static analysis
+	if err := lox.resolver.Resolve(program); err != nil {
+		return prefixLines("resolve error: ", err)
+	}
+
+	if lox.optimize {
+		program = NewOptimizer().Optimize(program)
+	}
+
+	if lox.engine == EngineVM {
+		return lox.runVM(module, program)
+	}
+
+	if err := lox.interpret(module.env, program); err != nil {
+		return fmt.Errorf("runtime error: %v", err)
+	}
+
+	return nil
+}
+
+func (lox *Lox) runVM(module *LoxModule, program []Stmt) error {
+	function, err := Compile(module, program)
+	if err != nil {
+		return prefixLines("compile error: ", err)
+	}
+
+	if _, err := NewVM(lox.maxCallDepth).Interpret(function); err != nil {
+		return fmt.Errorf("runtime error: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import "cjting.me/lox/scanner"
+
+// runtime values of the bytecode VM, lists, maps, modules and native
+// functions are shared with the tree-walking interpreter
+
+/*----------  Function  ----------*/
+
+// VMFunction is the compiled form of a function declaration
+type VMFunction struct {
+	// empty for top-level code
+	name         string
+	anonymous    bool
+	arity        int
+	upvalueCount int
+	chunk        *Chunk
+	// globals are looked up in the module where the function is defined
+	module *LoxModule
+}
+
+func NewVMFunction(name string, module *LoxModule) *VMFunction {
+	return &VMFunction{name: name, chunk: NewChunk(), module: module}
+}
+
+func (f *VMFunction) String() string {
+	if f.anonymous {
+		return "<fn anonymous>"
+	}
+	if f.name == "" {
+		return "<script>"
+	}
+	return "<fn " + f.name + ">"
+}
+
+/*----------  Closure  ----------*/
+
+type VMClosure struct {
+	function *VMFunction
+	upvalues []*VMUpvalue
+}
+
+func NewVMClosure(function *VMFunction) *VMClosure {
+	return &VMClosure{function, make([]*VMUpvalue, function.upvalueCount)}
+}
+
+func (c *VMClosure) String() string {
+	return c.function.String()
+}
+
+// VMUpvalue is a variable captured by a closure, it refers to a stack
+// slot until the variable goes out of scope, then it holds the value
+type VMUpvalue struct {
+	slot   int
+	open   bool
+	closed Val
+}
+
+/*----------  Class  ----------*/
+
+type VMClass struct {
+	name    string
+	methods map[string]*VMClosure
+}
+
+func NewVMClass(name string) *VMClass {
+	return &VMClass{name, map[string]*VMClosure{}}
+}
+
+func (c *VMClass) String() string {
+	return c.name
+}
+
+type VMInstance struct {
+	class  *VMClass
+	fields map[string]Val
+}
+
+func NewVMInstance(class *VMClass) *VMInstance {
+	return &VMInstance{class, map[string]Val{}}
+}
+
+func (i *VMInstance) String() string {
+	return i.class.name + " instance"
+}
+
+// fields shadow methods
+func (i *VMInstance) Get(name *scanner.Token) Val {
+	if val, ok := i.fields[name.Lexeme]; ok {
+		return val
+	}
+	if method, ok := i.class.methods[name.Lexeme]; ok {
+		return NewVMBoundMethod(i, method)
+	}
+	panic(NewRuntimeError(name, sprintf("undefined property '%s'", name.Lexeme)))
+}
+
+// method with `this` bound to receiver
+type VMBoundMethod struct {
+	receiver Val
+	method   *VMClosure
+}
+
+func NewVMBoundMethod(receiver Val, method *VMClosure) *VMBoundMethod {
+	return &VMBoundMethod{receiver, method}
+}
+
+func (m *VMBoundMethod) String() string {
+	return m.method.String()
+}
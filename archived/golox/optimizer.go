@@ -0,0 +1,228 @@
+package main
+
+import "cjting.me/lox/scanner"
+
+// Optimizer is an optional pass between resolver and execution, it folds
+// expressions whose operands are all literals and prunes branches whose
+// condition is a literal. Expressions which raise a runtime error are left
+// as they are, so the error is still reported at run time at the same line
+type Optimizer struct{}
+
+func NewOptimizer() *Optimizer {
+	return &Optimizer{}
+}
+
+// nodes are rewritten in place, the returned program can be shorter
+func (o *Optimizer) Optimize(program []Stmt) []Stmt {
+	return o.optimizeStmts(program)
+}
+
+/*----------  Stmt  ----------*/
+
+// pruned statements are dropped
+func (o *Optimizer) optimizeStmts(stmts []Stmt) []Stmt {
+	result := stmts[:0]
+	for _, stmt := range stmts {
+		if s := o.optimizeStmt(stmt); s != nil {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// return nil if the statement does nothing
+func (o *Optimizer) optimizeStmt(stmt Stmt) Stmt {
+	switch s := stmt.(type) {
+	case *StmtExpression:
+		s.expr = o.optimizeExpr(s.expr)
+	case *StmtPrint:
+		s.expr = o.optimizeExpr(s.expr)
+	case *StmtVarDecl:
+		if s.value != nil {
+			s.value = o.optimizeExpr(s.value)
+		}
+	case *StmtBlock:
+		s.stmts = o.optimizeStmts(s.stmts)
+	case *StmtIf:
+		s.condition = o.optimizeExpr(s.condition)
+		if literal, ok := s.condition.(*ExprLiteral); ok {
+			if getTruthy(literal.value) {
+				return o.optimizeStmt(s.trueBranch)
+			}
+			if s.falseBranch != nil {
+				return o.optimizeStmt(s.falseBranch)
+			}
+			return nil
+		}
+		s.trueBranch = o.optimizeBody(s.trueBranch)
+		if s.falseBranch != nil {
+			s.falseBranch = o.optimizeStmt(s.falseBranch)
+		}
+	case *StmtWhile:
+		s.condition = o.optimizeExpr(s.condition)
+		if literal, ok := s.condition.(*ExprLiteral); ok && !getTruthy(literal.value) {
+			return nil
+		}
+		s.body = o.optimizeBody(s.body)
+		if s.increment != nil {
+			s.increment = o.optimizeExpr(s.increment)
+		}
+	case *StmtForIn:
+		s.iterable = o.optimizeExpr(s.iterable)
+		s.body = o.optimizeBody(s.body)
+	case *StmtSwitch:
+		s.value = o.optimizeExpr(s.value)
+		for _, c := range s.cases {
+			for i, value := range c.values {
+				c.values[i] = o.optimizeExpr(value)
+			}
+			o.optimizeStmt(c.body)
+		}
+		if s.defaultBody != nil {
+			o.optimizeStmt(s.defaultBody)
+		}
+	case *StmtFuncDecl:
+		s.body = o.optimizeStmts(s.body)
+	case *StmtClassDecl:
+		for _, method := range s.methods {
+			o.optimizeStmt(method)
+		}
+	case *StmtReturn:
+		if s.value != nil {
+			s.value = o.optimizeExpr(s.value)
+		}
+	case *StmtThrow:
+		s.value = o.optimizeExpr(s.value)
+	case *StmtTry:
+		o.optimizeStmt(s.body)
+		if s.catchName != nil {
+			s.catchBody = o.optimizeStmts(s.catchBody)
+		}
+		if s.finallyBody != nil {
+			o.optimizeStmt(s.finallyBody)
+		}
+	case *StmtBreak, *StmtContinue, *StmtImport:
+		// nothing to do
+	default:
+		panic(sprintf("optimizer: unknown stmt %T", stmt))
+	}
+	return stmt
+}
+
+// body of if and loops can't be removed, it becomes an empty block
+func (o *Optimizer) optimizeBody(stmt Stmt) Stmt {
+	if s := o.optimizeStmt(stmt); s != nil {
+		return s
+	}
+	return NewStmtBlock(nil)
+}
+
+/*----------  Expr  ----------*/
+
+func (o *Optimizer) optimizeExpr(expr Expr) Expr {
+	switch e := expr.(type) {
+	case *ExprLiteral, *ExprVariable, *ExprThis, *ExprSuper:
+		// nothing to do
+	case *ExprUnary:
+		e.operand = o.optimizeExpr(e.operand)
+		if isLiteral(e.operand) {
+			return o.fold(e)
+		}
+	case *ExprBinary:
+		e.left = o.optimizeExpr(e.left)
+		e.right = o.optimizeExpr(e.right)
+		if isLiteral(e.left) && isLiteral(e.right) {
+			return o.fold(e)
+		}
+	case *ExprGrouping:
+		e.operand = o.optimizeExpr(e.operand)
+		if isLiteral(e.operand) {
+			return e.operand
+		}
+	case *ExprLogical:
+		e.left = o.optimizeExpr(e.left)
+		e.right = o.optimizeExpr(e.right)
+		// `or` gives left if it's truthy, `and` gives left if it's falsy
+		if literal, ok := e.left.(*ExprLiteral); ok {
+			if getTruthy(literal.value) == (e.operator.Type == scanner.OR) {
+				return literal
+			}
+			return e.right
+		}
+	case *ExprInterpolation:
+		for i, part := range e.parts {
+			e.parts[i] = o.optimizeExpr(part)
+		}
+	case *ExprAssignment:
+		e.val = o.optimizeExpr(e.val)
+	case *ExprCompoundAssign:
+		e.val = o.optimizeExpr(e.val)
+	case *ExprIncrement:
+		// nothing to do
+	case *ExprTernary:
+		e.condition = o.optimizeExpr(e.condition)
+		e.thenBranch = o.optimizeExpr(e.thenBranch)
+		e.elseBranch = o.optimizeExpr(e.elseBranch)
+	case *ExprComma:
+		e.left = o.optimizeExpr(e.left)
+		e.right = o.optimizeExpr(e.right)
+	case *ExprCall:
+		e.callee = o.optimizeExpr(e.callee)
+		for i, arg := range e.arguments {
+			e.arguments[i] = o.optimizeExpr(arg)
+		}
+	case *ExprFunction:
+		o.optimizeStmt(e.declaration)
+	case *ExprGet:
+		e.object = o.optimizeExpr(e.object)
+	case *ExprSet:
+		e.object = o.optimizeExpr(e.object)
+		e.val = o.optimizeExpr(e.val)
+	case *ExprList:
+		for i, element := range e.elements {
+			e.elements[i] = o.optimizeExpr(element)
+		}
+	case *ExprMap:
+		for i := range e.keys {
+			e.keys[i] = o.optimizeExpr(e.keys[i])
+			e.values[i] = o.optimizeExpr(e.values[i])
+		}
+	case *ExprIndex:
+		e.object = o.optimizeExpr(e.object)
+		e.index = o.optimizeExpr(e.index)
+	case *ExprIndexSet:
+		e.object = o.optimizeExpr(e.object)
+		e.index = o.optimizeExpr(e.index)
+		e.val = o.optimizeExpr(e.val)
+	case *ExprSlice:
+		e.object = o.optimizeExpr(e.object)
+		if e.start != nil {
+			e.start = o.optimizeExpr(e.start)
+		}
+		if e.end != nil {
+			e.end = o.optimizeExpr(e.end)
+		}
+	default:
+		panic(sprintf("optimizer: unknown expr %T", expr))
+	}
+	return expr
+}
+
+// evaluate expr whose operands are literals, env is not needed,
+// expr is kept if it raises a runtime error
+func (o *Optimizer) fold(expr Expr) (result Expr) {
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(*RuntimeError); !ok {
+				panic(e)
+			}
+			result = expr
+		}
+	}()
+	return NewExprLiteral(expr.Eval(nil))
+}
+
+func isLiteral(expr Expr) bool {
+	_, ok := expr.(*ExprLiteral)
+	return ok
+}
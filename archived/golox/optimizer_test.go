@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cjting.me/lox/scanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func optimizeSource(source string) string {
+	tokens, _ := scanner.Scan(source)
+	program, _ := NewParser().Parse(tokens)
+	NewResolver().Resolve(program)
+
+	var printed []string
+	for _, stmt := range NewOptimizer().Optimize(program) {
+		printed = append(printed, stmt.Print())
+	}
+	return strings.Join(printed, "\n")
+}
+
+func TestOptimizerFold(t *testing.T) {
+	tests := map[string]string{
+		"print 60 * 60 * 24;":         "(print 86400)",
+		`print "a" + "b";`:            `(print "ab")`,
+		"print -(1 + 2) ** 2;":        "(print -9)",
+		"print !nil == (1 < 2);":      "(print true)",
+		"print ~0 & 5;":               "(print 5)",
+		"print 1 + a;":                "(print (+ 1 a))",
+		"print a * (2 + 3);":          "(print (* a 5))",
+		"print nil or a;":             "(print a)",
+		"print 1 or a;":               "(print 1)",
+		"print false and a;":          "(print false)",
+		"print (1 < 2) and a;":        "(print a)",
+		"print 1 / 0;":                "(print (/ 1 0))",
+		`print 2 * (1 + "a");`:        `(print (* 2 (group (+ 1 "a"))))`,
+		"var a = 1 ~/ 0 + 1;":         "(var a (+ (~/ 1 0) 1))",
+		"func f() { return 2 * 3; }":  "(func f () (return 6))",
+		"print [1 + 1, {2 * 2: -1}];": "(print (list 2 (map 4 -1)))",
+	}
+
+	for source, expected := range tests {
+		assert.Equal(t, expected, optimizeSource(source), source)
+	}
+}
+
+func TestOptimizerPrune(t *testing.T) {
+	tests := map[string]string{
+		"if (false) print 1;":                     "",
+		"if (1 > 2) print 1; else print 2;":       "(print 2)",
+		"if (true) print 1; else print 2;":        "(print 1)",
+		"while (false) print 1;\nprint 2;":        "(print 2)",
+		"while (a) if (false) print 1;":           "(while a (block))",
+		"for (var i = 0; 1 > 2; i++) print i;":    "(block (var i 0))",
+		"if (a) { if (nil) print 1; } else {}":    "(if a (block) (block))",
+		"func f() { while (!true) {} return 1; }": "(func f () (return 1))",
+	}
+
+	for source, expected := range tests {
+		assert.Equal(t, expected, optimizeSource(source), source)
+	}
+}
+
+// optimized programs print the same and report errors at the same line
+func TestLoxOptimize(t *testing.T) {
+	run := func(source string) (string, error) {
+		buf := &bytes.Buffer{}
+		prev := stdout
+		stdout = buf
+		defer func() { stdout = prev }()
+
+		lox := NewLox()
+		lox.SetOptimize(true)
+		err := lox.Eval(source)
+		return buf.String(), err
+	}
+
+	source := `
+var day = 60 * 60 * 24;
+var total = 0;
+for (var i = 0; i < 3; i++) {
+  total = total + 2 * day;
+  if (false) print "never";
+  if (1 < 2 and "x" + "y" == "xy") print "always ${-(1 + 1)}";
+}
+print total;
+`
+	out, err := run(source)
+	assert.Nil(t, err)
+	expectedOut, _ := runLox(source)
+	assert.Equal(t, expectedOut, out)
+
+	out, err = run("print 1 + 2;\nprint 60 * (1 / 0);")
+	assert.Equal(t, "3\n", out)
+	assert.EqualError(t, err, "runtime error: line 2, divide by zero")
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
 	"cjting.me/lox/scanner"
 )
@@ -10,6 +11,11 @@ type Parser struct {
 	tokens  []*scanner.Token
 	current int
 	length  int
+	errors  ParseErrors
+	// how many loops we are in, break and continue are only allowed in loops
+	loopDepth int
+	// break is also allowed in switch, it exits the switch
+	switchDepth int
 }
 
 type ParseError struct {
@@ -32,43 +38,63 @@ func (pe *ParseError) Error() string {
 	return fmt.Sprintf("line %d, %s, %s", token.Line, position, pe.msg)
 }
 
+// all errors found in one parse
+type ParseErrors []*ParseError
+
+func (errs ParseErrors) Error() string {
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
 func NewParser() *Parser {
 	return &Parser{}
 }
 
-func (p *Parser) Parse(tokens []*scanner.Token) (result []Stmt, err error) {
+// return ParseErrors with every error found if source is invalid
+func (p *Parser) Parse(tokens []*scanner.Token) ([]Stmt, error) {
 	p.reset(tokens)
-	defer func() {
-		if e := recover(); e != nil {
-			if pe, ok := e.(*ParseError); ok {
-				err = pe
-			} else {
-				panic(err)
-			}
-		}
-	}()
+	var result []Stmt
 	for !p.isAtEnd() {
-		result = append(result, p.Declaration())
+		if stmt := p.Declaration(); stmt != nil {
+			result = append(result, stmt)
+		}
 	}
-	return
+	if len(p.errors) > 0 {
+		return nil, p.errors
+	}
+	return result, nil
 }
 
 /*----------  Private Methods  ----------*/
 
+// return nil if there is a parse error, the error is recorded and
+// parser is synchronized to next statement
 func (p *Parser) Declaration() (result Stmt) {
-	// defer func() {
-	// 	if err := recover(); err != nil {
-	// 		if _, ok := err.(*ParseError); ok {
-	// 			p.synchronize()
-	// 			result = nil
-	// 		}
-	// 	}
-	// }()
+	defer func() {
+		if e := recover(); e != nil {
+			if pe, ok := e.(*ParseError); ok {
+				p.errors = append(p.errors, pe)
+				p.synchronize()
+				result = nil
+			} else {
+				panic(e)
+			}
+		}
+	}()
 
 	switch true {
+	case p.match(scanner.CLASS):
+		result = p.ClassDeclaration()
 	case p.match(scanner.VAR):
 		result = p.VarDeclaration()
-	case p.match(scanner.FUNC):
+	case p.match(scanner.IMPORT):
+		result = p.ImportDeclaration()
+	// `func (` starts an anonymous function expression statement
+	case p.check(scanner.FUNC) && p.peekN(2).Type == scanner.IDENTIFIER:
+		p.advance()
 		result = p.FuncDeclaration("function")
 	default:
 		result = p.Statement()
@@ -77,10 +103,35 @@ func (p *Parser) Declaration() (result Stmt) {
 	return
 }
 
-// kind should be one of: `function`
-func (p *Parser) FuncDeclaration(kind string) Stmt {
+func (p *Parser) ClassDeclaration() Stmt {
+	name := p.consume(scanner.IDENTIFIER, "expect class name")
+
+	var superclass *ExprVariable
+	if p.match(scanner.LESS) {
+		p.consume(scanner.IDENTIFIER, "expect superclass name")
+		superclass = NewExprVariable(p.previous())
+	}
+
+	p.consume(scanner.LEFT_BRACE, "expect '{' before class body")
+	var methods []*StmtFuncDecl
+	for !p.check(scanner.RIGHT_BRACE) && !p.isAtEnd() {
+		methods = append(methods, p.FuncDeclaration("method"))
+	}
+	p.consume(scanner.RIGHT_BRACE, "expect '}' after class body")
+
+	return NewStmtClassDecl(name, superclass, methods)
+}
+
+// kind should be one of: `function`, `method`
+func (p *Parser) FuncDeclaration(kind string) *StmtFuncDecl {
 	name := p.consume(scanner.IDENTIFIER, "expect "+kind+" name")
 	p.consume(scanner.LEFT_PAREN, "expect '(' after "+kind+" name")
+	parameters, body := p.functionBody(kind)
+	return NewStmtFuncDecl(name, parameters, body)
+}
+
+// parse parameters and body, '(' is consumed
+func (p *Parser) functionBody(kind string) ([]*scanner.Token, []Stmt) {
 	var parameters []*scanner.Token
 	if !p.check(scanner.RIGHT_PAREN) {
 		parameters = append(parameters, p.consume(scanner.IDENTIFIER, "expect parameter name"))
@@ -93,8 +144,23 @@ func (p *Parser) FuncDeclaration(kind string) Stmt {
 	}
 	p.consume(scanner.RIGHT_PAREN, "expect ')' after parameters")
 	p.consume(scanner.LEFT_BRACE, "expect '{' after "+kind+" body")
+
+	// a loop outside the function can't be broken from inside
+	enclosingLoopDepth, enclosingSwitchDepth := p.loopDepth, p.switchDepth
+	p.loopDepth, p.switchDepth = 0, 0
+	defer func() { p.loopDepth, p.switchDepth = enclosingLoopDepth, enclosingSwitchDepth }()
 	body := p.BlockStatement()
-	return NewStmtFuncDecl(name, parameters, body)
+
+	return parameters, body
+}
+
+func (p *Parser) ImportDeclaration() Stmt {
+	keyword := p.previous()
+	path := p.consume(scanner.STRING, "expect module path after 'import'")
+	p.consume(scanner.AS, "expect 'as' after module path")
+	name := p.consume(scanner.IDENTIFIER, "expect module name after 'as'")
+	p.consume(scanner.SEMICOLON, "expect ';' after import")
+	return NewStmtImport(keyword, path, name)
 }
 
 func (p *Parser) VarDeclaration() Stmt {
@@ -112,7 +178,9 @@ func (p *Parser) Statement() Stmt {
 		return p.PrintStatement()
 	}
 
-	if p.match(scanner.LEFT_BRACE) {
+	// `{ key: ...` can't start a block, it's a map literal
+	if p.check(scanner.LEFT_BRACE) && !p.isMapLiteral() {
+		p.advance()
 		return NewStmtBlock(p.BlockStatement())
 	}
 
@@ -124,13 +192,32 @@ func (p *Parser) Statement() Stmt {
 		return p.WhileStatement()
 	}
 
+	if p.match(scanner.SWITCH) {
+		return p.SwitchStatement()
+	}
+
 	if p.match(scanner.FOR) {
 		return p.ForStatement()
 	}
 
 	if p.match(scanner.RETURN) {
 		return p.ReturnStatement()
+	}
 
+	if p.match(scanner.BREAK) {
+		return p.BreakStatement()
+	}
+
+	if p.match(scanner.THROW) {
+		return p.ThrowStatement()
+	}
+
+	if p.match(scanner.TRY) {
+		return p.TryStatement()
+	}
+
+	if p.match(scanner.CONTINUE) {
+		return p.ContinueStatement()
 	}
 
 	return p.ExpressionStatement()
@@ -146,9 +233,68 @@ func (p *Parser) ReturnStatement() Stmt {
 	return NewStmtReturn(token, value)
 }
 
-// desugar for to while statement
+func (p *Parser) BreakStatement() Stmt {
+	keyword := p.previous()
+	if p.loopDepth == 0 && p.switchDepth == 0 {
+		p.error(keyword, "can't use 'break' outside of a loop or switch")
+	}
+	p.consume(scanner.SEMICOLON, "expect ';' after 'break'")
+	return NewStmtBreak(keyword)
+}
+
+func (p *Parser) ContinueStatement() Stmt {
+	keyword := p.previous()
+	if p.loopDepth == 0 {
+		p.error(keyword, "can't use 'continue' outside of a loop")
+	}
+	p.consume(scanner.SEMICOLON, "expect ';' after 'continue'")
+	return NewStmtContinue(keyword)
+}
+
+func (p *Parser) ThrowStatement() Stmt {
+	keyword := p.previous()
+	value := p.Expression()
+	p.consume(scanner.SEMICOLON, "expect ';' after thrown value")
+	return NewStmtThrow(keyword, value)
+}
+
+func (p *Parser) TryStatement() Stmt {
+	keyword := p.previous()
+	p.consume(scanner.LEFT_BRACE, "expect '{' after 'try'")
+	body := NewStmtBlock(p.BlockStatement())
+
+	var catchName *scanner.Token
+	var catchBody []Stmt
+	if p.match(scanner.CATCH) {
+		p.consume(scanner.LEFT_PAREN, "expect '(' after 'catch'")
+		catchName = p.consume(scanner.IDENTIFIER, "expect error variable name")
+		p.consume(scanner.RIGHT_PAREN, "expect ')' after error variable name")
+		p.consume(scanner.LEFT_BRACE, "expect '{' before catch body")
+		catchBody = p.BlockStatement()
+	}
+
+	var finallyBody *StmtBlock
+	if p.match(scanner.FINALLY) {
+		p.consume(scanner.LEFT_BRACE, "expect '{' after 'finally'")
+		finallyBody = NewStmtBlock(p.BlockStatement())
+	}
+
+	if catchName == nil && finallyBody == nil {
+		p.error(keyword, "expect 'catch' or 'finally' after try block")
+	}
+
+	return NewStmtTry(body, catchName, catchBody, finallyBody)
+}
+
+// desugar for to while statement, the increment is kept in while statement
+// so that `continue` won't skip it
 func (p *Parser) ForStatement() Stmt {
 	p.consume(scanner.LEFT_PAREN, "expect '(' after for")
+
+	if p.check(scanner.VAR) && p.peekN(3).Type == scanner.IN {
+		return p.ForInStatement()
+	}
+
 	var initializer Stmt
 
 	if !p.check(scanner.SEMICOLON) {
@@ -171,19 +317,14 @@ func (p *Parser) ForStatement() Stmt {
 	}
 	p.consume(scanner.RIGHT_PAREN, "expect ')' after clauses")
 
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
 	body := p.Statement()
 
-	if increment != nil {
-		body = NewStmtBlock([]Stmt{
-			body,
-			NewStmtExpression(increment),
-		})
-	}
-
 	if condition == nil {
 		condition = NewExprLiteral(true)
 	}
-	body = NewStmtWhile(condition, body)
+	body = NewStmtWhile(condition, body, increment)
 
 	if initializer != nil {
 		body = NewStmtBlock([]Stmt{
@@ -195,12 +336,79 @@ func (p *Parser) ForStatement() Stmt {
 	return body
 }
 
+func (p *Parser) ForInStatement() Stmt {
+	p.consume(scanner.VAR, "expect 'var' in for-in loop")
+	name := p.consume(scanner.IDENTIFIER, "expect variable name")
+	in := p.consume(scanner.IN, "expect 'in' after variable name")
+	iterable := p.Expression()
+	p.consume(scanner.RIGHT_PAREN, "expect ')' after for-in clause")
+
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+	body := p.Statement()
+
+	return NewStmtForIn(name, in, iterable, body)
+}
+
+// cases don't fall through, default runs when no case matches,
+// wherever it's written
+func (p *Parser) SwitchStatement() Stmt {
+	keyword := p.previous()
+	p.consume(scanner.LEFT_PAREN, "expect '(' after 'switch'")
+	value := p.Expression()
+	p.consume(scanner.RIGHT_PAREN, "expect ')' after switch value")
+	p.consume(scanner.LEFT_BRACE, "expect '{' before switch body")
+
+	p.switchDepth++
+	defer func() { p.switchDepth-- }()
+
+	var cases []*SwitchCase
+	var defaultBody *StmtBlock
+	for !p.check(scanner.RIGHT_BRACE) && !p.isAtEnd() {
+		if p.match(scanner.CASE) {
+			values := []Expr{p.Assignment()}
+			for p.match(scanner.COMMA) {
+				values = append(values, p.Assignment())
+			}
+			p.consume(scanner.COLON, "expect ':' after case values")
+			cases = append(cases, NewSwitchCase(values, p.caseBody()))
+		} else if p.match(scanner.DEFAULT) {
+			token := p.previous()
+			p.consume(scanner.COLON, "expect ':' after 'default'")
+			body := p.caseBody()
+			if defaultBody != nil {
+				p.error(token, "a switch can't have more than one 'default'")
+			}
+			defaultBody = body
+		} else {
+			panic(NewParseError(p.peek(), "expect 'case' or 'default' in switch body"))
+		}
+	}
+
+	p.consume(scanner.RIGHT_BRACE, "expect '}' after switch body")
+	return NewStmtSwitch(keyword, value, cases, defaultBody)
+}
+
+// statements until next case, default or end of switch
+func (p *Parser) caseBody() *StmtBlock {
+	var stmts []Stmt
+	for !p.check(scanner.CASE) && !p.check(scanner.DEFAULT) &&
+		!p.check(scanner.RIGHT_BRACE) && !p.isAtEnd() {
+		stmts = append(stmts, p.Declaration())
+	}
+	return NewStmtBlock(stmts)
+}
+
 func (p *Parser) WhileStatement() Stmt {
 	p.consume(scanner.LEFT_PAREN, "expect '(' after while")
 	condition := p.Expression()
 	p.consume(scanner.RIGHT_PAREN, "expect ')' after condition")
+
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
 	body := p.Statement()
-	return NewStmtWhile(condition, body)
+
+	return NewStmtWhile(condition, body, nil)
 }
 
 func (p *Parser) IfStatement() Stmt {
@@ -238,11 +446,22 @@ func (p *Parser) ExpressionStatement() Stmt {
 }
 
 func (p *Parser) Expression() Expr {
-	return p.Assignment()
+	return p.Comma()
+}
+
+func (p *Parser) Comma() Expr {
+	expr := p.Assignment()
+
+	for p.match(scanner.COMMA) {
+		right := p.Assignment()
+		expr = NewExprComma(expr, right)
+	}
+
+	return expr
 }
 
 func (p *Parser) Assignment() Expr {
-	expr := p.LogicalOr()
+	expr := p.Ternary()
 
 	if p.match(scanner.EQUAL) {
 		equal := p.previous()
@@ -252,9 +471,43 @@ func (p *Parser) Assignment() Expr {
 			return NewExprAssignment(e.name, value)
 		}
 
+		if e, ok := expr.(*ExprGet); ok {
+			return NewExprSet(e.object, e.name, value)
+		}
+
+		if e, ok := expr.(*ExprIndex); ok {
+			return NewExprIndexSet(e.object, e.bracket, e.index, value)
+		}
+
 		panic(NewParseError(equal, "invalid assignment target"))
 	}
 
+	if p.match(scanner.PLUS_EQUAL, scanner.MINUS_EQUAL, scanner.STAR_EQUAL,
+		scanner.SLASH_EQUAL, scanner.PERCENT_EQUAL) {
+		operator := p.previous()
+		value := p.Assignment()
+
+		if e, ok := expr.(*ExprVariable); ok {
+			return NewExprCompoundAssign(e.name, operator, value)
+		}
+
+		panic(NewParseError(operator, "invalid assignment target"))
+	}
+
+	return expr
+}
+
+// ternary is right associative, `a ? b : c ? d : e` is `a ? b : (c ? d : e)`
+func (p *Parser) Ternary() Expr {
+	expr := p.LogicalOr()
+
+	if p.match(scanner.QUESTION) {
+		thenBranch := p.Assignment()
+		p.consume(scanner.COLON, "expect ':' after then branch of ternary expression")
+		elseBranch := p.Assignment()
+		return NewExprTernary(expr, thenBranch, elseBranch)
+	}
+
 	return expr
 }
 
@@ -271,17 +524,55 @@ func (p *Parser) LogicalOr() Expr {
 }
 
 func (p *Parser) LogicalAnd() Expr {
-	expr := p.Equality()
+	expr := p.BitOr()
 
 	for p.match(scanner.AND) {
 		operator := p.previous()
-		right := p.Equality()
+		right := p.BitOr()
 		expr = NewExprLogical(expr, operator, right)
 	}
 
 	return expr
 }
 
+// bitwise operators use the same precedence as C,
+// lower than equality: a & b == c is a & (b == c)
+func (p *Parser) BitOr() Expr {
+	expr := p.BitXor()
+
+	for p.match(scanner.PIPE) {
+		operator := p.previous()
+		right := p.BitXor()
+		expr = NewExprBinary(expr, operator, right)
+	}
+
+	return expr
+}
+
+func (p *Parser) BitXor() Expr {
+	expr := p.BitAnd()
+
+	for p.match(scanner.CARET) {
+		operator := p.previous()
+		right := p.BitAnd()
+		expr = NewExprBinary(expr, operator, right)
+	}
+
+	return expr
+}
+
+func (p *Parser) BitAnd() Expr {
+	expr := p.Equality()
+
+	for p.match(scanner.AMPERSAND) {
+		operator := p.previous()
+		right := p.Equality()
+		expr = NewExprBinary(expr, operator, right)
+	}
+
+	return expr
+}
+
 func (p *Parser) Equality() Expr {
 	expr := p.Comparison()
 
@@ -295,9 +586,21 @@ func (p *Parser) Equality() Expr {
 }
 
 func (p *Parser) Comparison() Expr {
-	expr := p.Addition()
+	expr := p.Shift()
 
 	for p.match(scanner.GREATER, scanner.GREATER_EQUAL, scanner.LESS, scanner.LESS_EQUAL) {
+		operator := p.previous()
+		right := p.Shift()
+		expr = NewExprBinary(expr, operator, right)
+	}
+
+	return expr
+}
+
+func (p *Parser) Shift() Expr {
+	expr := p.Addition()
+
+	for p.match(scanner.LESS_LESS, scanner.GREATER_GREATER) {
 		operator := p.previous()
 		right := p.Addition()
 		expr = NewExprBinary(expr, operator, right)
@@ -320,7 +623,7 @@ func (p *Parser) Addition() Expr {
 
 func (p *Parser) Multiplication() Expr {
 	expr := p.Unary()
-	for p.match(scanner.STAR, scanner.SLASH) {
+	for p.match(scanner.STAR, scanner.SLASH, scanner.PERCENT, scanner.TILDE_SLASH) {
 		operator := p.previous()
 		right := p.Unary()
 		expr = NewExprBinary(expr, operator, right)
@@ -330,13 +633,40 @@ func (p *Parser) Multiplication() Expr {
 }
 
 func (p *Parser) Unary() Expr {
-	if p.match(scanner.BANG, scanner.MINUS) {
+	if p.match(scanner.BANG, scanner.MINUS, scanner.TILDE) {
 		operator := p.previous()
 		operand := p.Unary()
 		return NewExprUnary(operator, operand)
 	}
 
-	return p.Call()
+	if p.match(scanner.PLUS_PLUS, scanner.MINUS_MINUS) {
+		operator := p.previous()
+		operand := p.Unary()
+		return NewExprIncrement(p.incrementTarget(operator, operand), operator, true)
+	}
+
+	return p.Power()
+}
+
+// right associative and binds tighter than unary on the left,
+// -2 ** 2 is -(2 ** 2), 2 ** -1 is 2 ** (-1)
+func (p *Parser) Power() Expr {
+	expr := p.Postfix()
+	if p.match(scanner.STAR_STAR) {
+		operator := p.previous()
+		right := p.Unary()
+		expr = NewExprBinary(expr, operator, right)
+	}
+	return expr
+}
+
+func (p *Parser) Postfix() Expr {
+	expr := p.Call()
+	if p.match(scanner.PLUS_PLUS, scanner.MINUS_MINUS) {
+		operator := p.previous()
+		return NewExprIncrement(p.incrementTarget(operator, expr), operator, false)
+	}
+	return expr
 }
 
 func (p *Parser) Call() Expr {
@@ -344,6 +674,11 @@ func (p *Parser) Call() Expr {
 	for true {
 		if p.match(scanner.LEFT_PAREN) {
 			expr = p.finishCall(expr)
+		} else if p.match(scanner.DOT) {
+			name := p.consume(scanner.IDENTIFIER, "expect property name after '.'")
+			expr = NewExprGet(expr, name)
+		} else if p.match(scanner.LEFT_BRACKET) {
+			expr = p.finishIndex(expr)
 		} else {
 			break
 		}
@@ -368,16 +703,109 @@ func (p *Parser) Primary() Expr {
 		return NewExprLiteral(p.previous().Literal)
 	}
 
+	if p.match(scanner.INTERPOLATION) {
+		return p.finishInterpolation()
+	}
+
 	if p.match(scanner.LEFT_PAREN) {
 		expr := p.Expression()
 		p.consume(scanner.RIGHT_PAREN, "expect ')' after expression")
 		return NewExprGrouping(expr)
 	}
 
+	if p.match(scanner.LEFT_BRACE) {
+		return p.finishMap()
+	}
+
+	if p.match(scanner.LEFT_BRACKET) {
+		var elements []Expr
+		if !p.check(scanner.RIGHT_BRACKET) {
+			elements = append(elements, p.Assignment())
+			for p.match(scanner.COMMA) {
+				elements = append(elements, p.Assignment())
+			}
+		}
+		p.consume(scanner.RIGHT_BRACKET, "expect ']' after list elements")
+		return NewExprList(elements)
+	}
+
+	if p.match(scanner.THIS) {
+		return NewExprThis(p.previous())
+	}
+
+	if p.match(scanner.FUNC) {
+		p.consume(scanner.LEFT_PAREN, "expect '(' after 'func'")
+		parameters, body := p.functionBody("function")
+		return NewExprFunction(NewStmtFuncDecl(nil, parameters, body))
+	}
+
+	if p.match(scanner.SUPER) {
+		keyword := p.previous()
+		p.consume(scanner.DOT, "expect '.' after 'super'")
+		method := p.consume(scanner.IDENTIFIER, "expect superclass method name")
+		return NewExprSuper(keyword, method)
+	}
+
 	if p.match(scanner.IDENTIFIER) {
 		return NewExprVariable(p.previous())
 	}
 
+	// error productions: binary operator without left-hand operand,
+	// parse and discard the right operand so that parsing can continue
+	if p.match(scanner.BANG_EQUAL, scanner.EQUAL_EQUAL) {
+		p.missingLeftOperand(p.previous())
+		p.Equality()
+		return NewExprLiteral(nil)
+	}
+
+	if p.match(scanner.PIPE) {
+		p.missingLeftOperand(p.previous())
+		p.BitOr()
+		return NewExprLiteral(nil)
+	}
+
+	if p.match(scanner.CARET) {
+		p.missingLeftOperand(p.previous())
+		p.BitXor()
+		return NewExprLiteral(nil)
+	}
+
+	if p.match(scanner.AMPERSAND) {
+		p.missingLeftOperand(p.previous())
+		p.BitAnd()
+		return NewExprLiteral(nil)
+	}
+
+	if p.match(scanner.GREATER, scanner.GREATER_EQUAL, scanner.LESS, scanner.LESS_EQUAL) {
+		p.missingLeftOperand(p.previous())
+		p.Comparison()
+		return NewExprLiteral(nil)
+	}
+
+	if p.match(scanner.LESS_LESS, scanner.GREATER_GREATER) {
+		p.missingLeftOperand(p.previous())
+		p.Shift()
+		return NewExprLiteral(nil)
+	}
+
+	if p.match(scanner.PLUS) {
+		p.missingLeftOperand(p.previous())
+		p.Addition()
+		return NewExprLiteral(nil)
+	}
+
+	if p.match(scanner.STAR, scanner.SLASH, scanner.PERCENT, scanner.TILDE_SLASH) {
+		p.missingLeftOperand(p.previous())
+		p.Multiplication()
+		return NewExprLiteral(nil)
+	}
+
+	if p.match(scanner.STAR_STAR) {
+		p.missingLeftOperand(p.previous())
+		p.Unary()
+		return NewExprLiteral(nil)
+	}
+
 	panic(NewParseError(p.peek(), "expect expression"))
 }
 
@@ -386,6 +814,26 @@ func (p *Parser) reset(tokens []*scanner.Token) {
 	p.tokens = tokens
 	p.length = len(tokens)
 	p.current = 0
+	p.errors = nil
+	p.loopDepth = 0
+	p.switchDepth = 0
+}
+
+// record an error without stopping parsing
+func (p *Parser) error(token *scanner.Token, msg string) {
+	p.errors = append(p.errors, NewParseError(token, msg))
+}
+
+func (p *Parser) missingLeftOperand(operator *scanner.Token) {
+	p.error(operator, sprintf("missing left-hand operand for '%s'", operator.Lexeme))
+}
+
+// the variable name of `++` and `--` operand
+func (p *Parser) incrementTarget(operator *scanner.Token, operand Expr) *scanner.Token {
+	if e, ok := operand.(*ExprVariable); ok {
+		return e.name
+	}
+	panic(NewParseError(operator, sprintf("invalid operand for '%s'", operator.Lexeme)))
 }
 
 func (p *Parser) isAtEnd() bool {
@@ -396,6 +844,37 @@ func (p *Parser) peek() *scanner.Token {
 	return p.tokens[p.current]
 }
 
+// peekN(1) is peek(), return EOF if out of bound
+// parse ahead to see whether `{` is followed by a key and ':', the parser
+// is rewound afterwards, `{}` is an empty block
+func (p *Parser) isMapLiteral() (isMap bool) {
+	current, errors := p.current, len(p.errors)
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(*ParseError); !ok {
+				panic(e)
+			}
+			isMap = false
+		}
+		p.current, p.errors = current, p.errors[:errors]
+	}()
+
+	p.advance()
+	if p.check(scanner.RIGHT_BRACE) {
+		return false
+	}
+	p.Assignment()
+	return p.check(scanner.COLON)
+}
+
+func (p *Parser) peekN(n int) *scanner.Token {
+	i := p.current + n - 1
+	if i >= p.length {
+		return p.tokens[p.length-1]
+	}
+	return p.tokens[i]
+}
+
 func (p *Parser) previous() *scanner.Token {
 	return p.tokens[p.current-1]
 }
@@ -433,15 +912,16 @@ func (p *Parser) consume(typ scanner.TokenType, msg string) *scanner.Token {
 
 func (p *Parser) finishCall(callee Expr) Expr {
 	var arguments []Expr
+	// arguments are parsed as assignment, so that comma acts as separator
 	if !p.check(scanner.RIGHT_PAREN) {
-		arguments = append(arguments, p.Expression())
+		arguments = append(arguments, p.Assignment())
 		for p.match(scanner.COMMA) {
 			// in order to compitable with C implementation, we limit
 			// argument size
 			if len(arguments) >= 8 {
 				panic(NewParseError(p.peek(), "can't have more than 8 arguments"))
 			}
-			arguments = append(arguments, p.Expression())
+			arguments = append(arguments, p.Assignment())
 		}
 	}
 
@@ -449,5 +929,76 @@ func (p *Parser) finishCall(callee Expr) Expr {
 	return NewExprCall(callee, paren, arguments)
 }
 
+// scanner makes sure every INTERPOLATION is followed by an expression
+// and another INTERPOLATION or the last STRING part
+func (p *Parser) finishInterpolation() Expr {
+	var parts []Expr
+	for {
+		parts = append(parts, NewExprLiteral(p.previous().Literal))
+		parts = append(parts, p.Expression())
+		if !p.match(scanner.INTERPOLATION) {
+			break
+		}
+	}
+	p.consume(scanner.STRING, "expect '}' after interpolated expression")
+	parts = append(parts, NewExprLiteral(p.previous().Literal))
+	return NewExprInterpolation(parts)
+}
+
+func (p *Parser) finishMap() Expr {
+	brace := p.previous()
+	var keys, values []Expr
+	if !p.check(scanner.RIGHT_BRACE) {
+		for {
+			keys = append(keys, p.Assignment())
+			p.consume(scanner.COLON, "expect ':' after map key")
+			values = append(values, p.Assignment())
+			if !p.match(scanner.COMMA) {
+				break
+			}
+		}
+	}
+	p.consume(scanner.RIGHT_BRACE, "expect '}' after map entries")
+	return NewExprMap(brace, keys, values)
+}
+
+// `[index]` or `[start:end]`, start and end are optional
+func (p *Parser) finishIndex(object Expr) Expr {
+	var start Expr
+	if !p.check(scanner.COLON) {
+		start = p.Expression()
+	}
+
+	if p.match(scanner.COLON) {
+		var end Expr
+		if !p.check(scanner.RIGHT_BRACKET) {
+			end = p.Expression()
+		}
+		bracket := p.consume(scanner.RIGHT_BRACKET, "expect ']' after slice")
+		return NewExprSlice(object, bracket, start, end)
+	}
+
+	bracket := p.consume(scanner.RIGHT_BRACKET, "expect ']' after index")
+	return NewExprIndex(object, bracket, start)
+}
+
+// discard tokens until the beginning of next statement
 func (p *Parser) synchronize() {
+	p.advance()
+
+	for !p.isAtEnd() {
+		if p.previous().Type == scanner.SEMICOLON {
+			return
+		}
+
+		switch p.peek().Type {
+		case scanner.CLASS, scanner.FUNC, scanner.VAR, scanner.FOR,
+			scanner.IF, scanner.WHILE, scanner.SWITCH, scanner.PRINT, scanner.RETURN,
+			scanner.BREAK, scanner.CONTINUE, scanner.THROW, scanner.TRY,
+			scanner.IMPORT:
+			return
+		}
+
+		p.advance()
+	}
 }
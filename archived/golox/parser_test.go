@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
 	"cjting.me/lox/scanner"
@@ -8,12 +9,12 @@ import (
 )
 
 func TestParserParse(t *testing.T) {
-	// 1 + 2 * 3 - 4
-	tokens, _ := scanner.Scan("1 + 2 * 3 - 4")
+	// 1 + 2 * 3 - 4;
+	tokens, _ := scanner.Scan("1 + 2 * 3 - 4;")
 	parser := NewParser()
-	expr, err := parser.Parse(tokens)
+	program, err := parser.Parse(tokens)
 
-	expected := NewExprBinary(
+	expected := []Stmt{NewStmtExpression(NewExprBinary(
 		NewExprBinary(
 			NewExprLiteral(1.0),
 			scanner.NewToken(scanner.PLUS, "+", nil, 1.0),
@@ -25,8 +26,156 @@ func TestParserParse(t *testing.T) {
 		),
 		scanner.NewToken(scanner.MINUS, "-", nil, 1.0),
 		NewExprLiteral(4.0),
-	)
+	))}
 
 	assert.Nil(t, err)
-	assert.Equal(t, expected, expr)
+	assert.Equal(t, expected, program)
+}
+
+func TestParserTernaryAndComma(t *testing.T) {
+	tests := map[string]string{
+		"a ? b : c":         "(?: a b c)",
+		"a ? b : c ? d : e": "(?: a b (?: c d e))",
+		"a ? b ? c : d : e": "(?: a (?: b c d) e)",
+		"a or b ? 1 : 2":    "(?: (or a b) 1 2)",
+		"a = b ? 1 : 2":     "(assign a (?: b 1 2))",
+		"a, b, c":           "(, (, a b) c)",
+		"a = 1, b = 2":      "(, (assign a 1) (assign b 2))",
+		"f(a, b ? 1 : 2)":   "(f a (?: b 1 2))",
+		"f((a, b), c)":      "(f (group (, a b)) c)",
+		"a ? b = 1 : c = 2": "(?: a (assign b 1) (assign c 2))",
+	}
+
+	for source, expected := range tests {
+		tokens, _ := scanner.Scan(source)
+		parser := NewParser()
+		parser.reset(tokens)
+		assert.Equal(t, expected, parser.Expression().Print(), source)
+	}
+}
+
+func TestParserArithmetic(t *testing.T) {
+	tests := map[string]string{
+		"2 ** 3 ** 2":    "(** 2 (** 3 2))",
+		"-2 ** 2":        "(- (** 2 2))",
+		"2 ** -1":        "(** 2 (- 1))",
+		"a * b ** c":     "(* a (** b c))",
+		"a % b * c ~/ d": "(~/ (* (% a b) c) d)",
+		"a + b % c":      "(+ a (% b c))",
+		"a | b ^ c & d":  "(| a (^ b (& c d)))",
+		"a & b == c":     "(& a (== b c))",
+		"a << b + c < d": "(< (<< a (+ b c)) d)",
+		"~a & -b":        "(& (~ a) (- b))",
+		"a and b | c":    "(and a (| b c))",
+	}
+
+	for source, expected := range tests {
+		tokens, _ := scanner.Scan(source)
+		parser := NewParser()
+		parser.reset(tokens)
+		assert.Equal(t, expected, parser.Expression().Print(), source)
+	}
+}
+
+func TestParserCompoundAssignment(t *testing.T) {
+	tests := map[string]string{
+		"a += 1":       "(+= a 1)",
+		"a -= b *= 2":  "(-= a (*= b 2))",
+		"a %= b ? 1:2": "(%= a (?: b 1 2))",
+		"i++":          "(i ++)",
+		"--i":          "(-- i)",
+		"-i++":         "(- (i ++))",
+		"a + b++":      "(+ a (b ++))",
+	}
+
+	for source, expected := range tests {
+		tokens, _ := scanner.Scan(source)
+		parser := NewParser()
+		parser.reset(tokens)
+		assert.Equal(t, expected, parser.Expression().Print(), source)
+	}
+
+	errors := map[string]string{
+		"1 += 2;":   "line 1, at '+=', invalid assignment target",
+		"a.b -= 2;": "line 1, at '-=', invalid assignment target",
+		"f()++;":    "line 1, at '++', invalid operand for '++'",
+		"--1;":      "line 1, at '--', invalid operand for '--'",
+		"a[0]++;":   "line 1, at '++', invalid operand for '++'",
+	}
+
+	for source, msg := range errors {
+		tokens, _ := scanner.Scan(source)
+		_, err := NewParser().Parse(tokens)
+		assert.EqualError(t, err, msg, source)
+	}
+}
+
+func TestParserPrintSwitch(t *testing.T) {
+	tokens, _ := scanner.Scan(`switch (x) { case 1, "a": print 1; break; default: case 2: }`)
+	program, err := NewParser().Parse(tokens)
+	assert.Nil(t, err)
+	assert.Equal(t, `(switch x (case (1 "a") (print 1) (break)) (case (2)) (default))`, program[0].Print())
+}
+
+func TestParserMissingLeftOperand(t *testing.T) {
+	tests := map[string]string{
+		"== 1;":     "line 1, at '==', missing left-hand operand for '=='",
+		"!= 1;":     "line 1, at '!=', missing left-hand operand for '!='",
+		"> 1;":      "line 1, at '>', missing left-hand operand for '>'",
+		"<= 1 + 2;": "line 1, at '<=', missing left-hand operand for '<='",
+		"+ 1 * 2;":  "line 1, at '+', missing left-hand operand for '+'",
+		"* 2;":      "line 1, at '*', missing left-hand operand for '*'",
+		"/ 2;":      "line 1, at '/', missing left-hand operand for '/'",
+	}
+
+	for source, msg := range tests {
+		tokens, _ := scanner.Scan(source)
+		parser := NewParser()
+		_, err := parser.Parse(tokens)
+		assert.EqualError(t, err, msg, source)
+		// the right operand is consumed, so parsing reaches the end
+		assert.Len(t, parser.errors, 1, source)
+		assert.True(t, parser.isAtEnd(), source)
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	tokens, _ := scanner.Scan(`var a = ;
+print a;
+func f( {
+  var b = 1
+  return b;
+}
+print == 1;
+var c = 2;
+`)
+	program, err := NewParser().Parse(tokens)
+	assert.Nil(t, program)
+	errs, ok := err.(ParseErrors)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"line 1, at ';', expect expression",
+		"line 3, at '{', expect parameter name",
+		"line 5, at 'return', expect ';' after variable declaration",
+		// the stray '}' of the broken function body
+		"line 6, at '}', expect expression",
+		"line 7, at '==', missing left-hand operand for '=='",
+	}, strings.Split(errs.Error(), "\n"))
+}
+
+func TestParserPrintFunction(t *testing.T) {
+	tests := map[string]string{
+		"func (a, b) { return a + b; };":    "(; (func (a b) (return (+ a b))))",
+		"f(func () {});":                    "(; (f (func ())))",
+		"func g(a) { var x = a; print x; }": "(func g (a) (var x a) (print x))",
+		"func (x) { }(1);":                  "(; ((func (x)) 1))",
+	}
+
+	for source, expected := range tests {
+		tokens, _ := scanner.Scan(source)
+		program, err := NewParser().Parse(tokens)
+		if assert.Nil(t, err, source) {
+			assert.Equal(t, expected, program[0].Print(), source)
+		}
+	}
 }
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"cjting.me/lox/scanner"
+)
+
+// Resolver is a static pass between parsing and interpreting, it binds every
+// variable reference to the scope where it's declared, so that closures
+// always see the same variable no matter when they are called
+type Resolver struct {
+	scopes          []map[string]*variable
+	currentFunction FunctionType
+	currentClass    ClassType
+	errors          ResolveErrors
+}
+
+// local variable in a scope
+type variable struct {
+	// index in the scope, in declaration order
+	slot int
+	// false until the initializer is resolved
+	ready bool
+}
+
+type FunctionType int
+
+const (
+	FunctionTypeNone FunctionType = iota
+	FunctionTypeFunction
+	FunctionTypeMethod
+	FunctionTypeInitializer
+)
+
+type ClassType int
+
+const (
+	ClassTypeNone ClassType = iota
+	ClassTypeClass
+	ClassTypeSubclass
+)
+
+type ResolveError struct {
+	token *scanner.Token
+	msg   string
+}
+
+func NewResolveError(token *scanner.Token, msg string) *ResolveError {
+	return &ResolveError{token, msg}
+}
+
+func (re *ResolveError) Error() string {
+	return fmt.Sprintf("line %d, at '%s', %s", re.token.Line, re.token.Lexeme, re.msg)
+}
+
+// all errors found in one pass
+type ResolveErrors []*ResolveError
+
+func (errs ResolveErrors) Error() string {
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{}
+}
+
+// return ResolveErrors if program is semantically invalid
+func (r *Resolver) Resolve(program []Stmt) error {
+	r.scopes = nil
+	r.currentFunction = FunctionTypeNone
+	r.currentClass = ClassTypeNone
+	r.errors = nil
+
+	r.resolveStmts(program)
+
+	if len(r.errors) > 0 {
+		return r.errors
+	}
+	return nil
+}
+
+/*----------  Stmt  ----------*/
+
+func (r *Resolver) resolveStmts(stmts []Stmt) {
+	for _, stmt := range stmts {
+		r.resolveStmt(stmt)
+	}
+}
+
+func (r *Resolver) resolveStmt(stmt Stmt) {
+	switch s := stmt.(type) {
+	case *StmtBlock:
+		r.beginScope()
+		r.resolveStmts(s.stmts)
+		r.endScope()
+	case *StmtVarDecl:
+		r.declare(s.name)
+		if s.value != nil {
+			r.resolveExpr(s.value)
+		}
+		r.define(s.name)
+	case *StmtFuncDecl:
+		// define eagerly to support recursion
+		r.declare(s.name)
+		r.define(s.name)
+		r.resolveFunction(s, FunctionTypeFunction)
+	case *StmtClassDecl:
+		r.resolveClass(s)
+	case *StmtExpression:
+		r.resolveExpr(s.expr)
+	case *StmtPrint:
+		r.resolveExpr(s.expr)
+	case *StmtIf:
+		r.resolveExpr(s.condition)
+		r.resolveStmt(s.trueBranch)
+		if s.falseBranch != nil {
+			r.resolveStmt(s.falseBranch)
+		}
+	case *StmtWhile:
+		r.resolveExpr(s.condition)
+		r.resolveStmt(s.body)
+		if s.increment != nil {
+			r.resolveExpr(s.increment)
+		}
+	case *StmtForIn:
+		r.resolveExpr(s.iterable)
+		r.beginScope()
+		r.declare(s.name)
+		r.define(s.name)
+		r.resolveStmt(s.body)
+		r.endScope()
+	case *StmtSwitch:
+		r.resolveExpr(s.value)
+		for _, c := range s.cases {
+			for _, value := range c.values {
+				r.resolveExpr(value)
+			}
+			r.resolveStmt(c.body)
+		}
+		if s.defaultBody != nil {
+			r.resolveStmt(s.defaultBody)
+		}
+	case *StmtImport:
+		r.declare(s.name)
+		r.define(s.name)
+	case *StmtThrow:
+		r.resolveExpr(s.value)
+	case *StmtTry:
+		r.resolveStmt(s.body)
+		if s.catchName != nil {
+			r.beginScope()
+			r.declare(s.catchName)
+			r.define(s.catchName)
+			r.resolveStmts(s.catchBody)
+			r.endScope()
+		}
+		if s.finallyBody != nil {
+			r.resolveStmt(s.finallyBody)
+		}
+	case *StmtBreak, *StmtContinue:
+		// nothing to do
+	case *StmtReturn:
+		if r.currentFunction == FunctionTypeNone {
+			r.error(s.token, "can't return from top-level code")
+		}
+		if s.value != nil {
+			if r.currentFunction == FunctionTypeInitializer {
+				r.error(s.token, "can't return a value from an initializer")
+			}
+			r.resolveExpr(s.value)
+		}
+	default:
+		panic(sprintf("resolver: unknown stmt %T", stmt))
+	}
+}
+
+func (r *Resolver) resolveFunction(s *StmtFuncDecl, typ FunctionType) {
+	enclosingFunction := r.currentFunction
+	r.currentFunction = typ
+
+	r.beginScope()
+	for _, param := range s.parameters {
+		r.declare(param)
+		r.define(param)
+	}
+	r.resolveStmts(s.body)
+	r.endScope()
+
+	r.currentFunction = enclosingFunction
+}
+
+func (r *Resolver) resolveClass(s *StmtClassDecl) {
+	enclosingClass := r.currentClass
+	r.currentClass = ClassTypeClass
+
+	r.declare(s.name)
+	r.define(s.name)
+
+	if s.superclass != nil {
+		if s.superclass.name.Lexeme == s.name.Lexeme {
+			r.error(s.superclass.name, "a class can't inherit from itself")
+		}
+		r.currentClass = ClassTypeSubclass
+		r.resolveExpr(s.superclass)
+
+		r.beginScope()
+		r.scopes[len(r.scopes)-1]["super"] = &variable{0, true}
+	}
+
+	r.beginScope()
+	r.scopes[len(r.scopes)-1]["this"] = &variable{0, true}
+
+	for _, method := range s.methods {
+		typ := FunctionTypeMethod
+		if method.name.Lexeme == "init" {
+			typ = FunctionTypeInitializer
+		}
+		r.resolveFunction(method, typ)
+	}
+
+	r.endScope()
+
+	if s.superclass != nil {
+		r.endScope()
+	}
+
+	r.currentClass = enclosingClass
+}
+
+/*----------  Expr  ----------*/
+
+func (r *Resolver) resolveExpr(expr Expr) {
+	switch e := expr.(type) {
+	case *ExprVariable:
+		r.checkInitialized(e.name)
+		e.depth, e.slot = r.resolveLocal(e.name)
+	case *ExprAssignment:
+		r.resolveExpr(e.val)
+		e.depth, e.slot = r.resolveLocal(e.name)
+	case *ExprCompoundAssign:
+		r.checkInitialized(e.name)
+		r.resolveExpr(e.val)
+		e.depth, e.slot = r.resolveLocal(e.name)
+	case *ExprIncrement:
+		r.checkInitialized(e.name)
+		e.depth, e.slot = r.resolveLocal(e.name)
+	case *ExprLiteral:
+		// nothing to do
+	case *ExprInterpolation:
+		for _, part := range e.parts {
+			r.resolveExpr(part)
+		}
+	case *ExprUnary:
+		r.resolveExpr(e.operand)
+	case *ExprBinary:
+		r.resolveExpr(e.left)
+		r.resolveExpr(e.right)
+	case *ExprGrouping:
+		r.resolveExpr(e.operand)
+	case *ExprLogical:
+		r.resolveExpr(e.left)
+		r.resolveExpr(e.right)
+	case *ExprTernary:
+		r.resolveExpr(e.condition)
+		r.resolveExpr(e.thenBranch)
+		r.resolveExpr(e.elseBranch)
+	case *ExprComma:
+		r.resolveExpr(e.left)
+		r.resolveExpr(e.right)
+	case *ExprCall:
+		r.resolveExpr(e.callee)
+		for _, arg := range e.arguments {
+			r.resolveExpr(arg)
+		}
+	case *ExprFunction:
+		r.resolveFunction(e.declaration, FunctionTypeFunction)
+	case *ExprGet:
+		r.resolveExpr(e.object)
+	case *ExprSet:
+		r.resolveExpr(e.val)
+		r.resolveExpr(e.object)
+	case *ExprThis:
+		if r.currentClass == ClassTypeNone {
+			r.error(e.keyword, "can't use 'this' outside of a class")
+		}
+		e.depth, _ = r.resolveLocal(e.keyword)
+	case *ExprSuper:
+		if r.currentClass == ClassTypeNone {
+			r.error(e.keyword, "can't use 'super' outside of a class")
+		} else if r.currentClass != ClassTypeSubclass {
+			r.error(e.keyword, "can't use 'super' in a class with no superclass")
+		}
+		e.depth, _ = r.resolveLocal(e.keyword)
+	case *ExprList:
+		for _, element := range e.elements {
+			r.resolveExpr(element)
+		}
+	case *ExprMap:
+		for i := range e.keys {
+			r.resolveExpr(e.keys[i])
+			r.resolveExpr(e.values[i])
+		}
+	case *ExprIndex:
+		r.resolveExpr(e.object)
+		r.resolveExpr(e.index)
+	case *ExprIndexSet:
+		r.resolveExpr(e.object)
+		r.resolveExpr(e.index)
+		r.resolveExpr(e.val)
+	case *ExprSlice:
+		r.resolveExpr(e.object)
+		if e.start != nil {
+			r.resolveExpr(e.start)
+		}
+		if e.end != nil {
+			r.resolveExpr(e.end)
+		}
+	default:
+		panic(sprintf("resolver: unknown expr %T", expr))
+	}
+}
+
+// return how many scopes away the variable is declared and its slot,
+// depth -1 means it's not found and should be a global variable
+func (r *Resolver) resolveLocal(name *scanner.Token) (depth int, slot int) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if v, ok := r.scopes[i][name.Lexeme]; ok {
+			return len(r.scopes) - 1 - i, v.slot
+		}
+	}
+	return -1, 0
+}
+
+/*----------  Helper Methods  ----------*/
+
+// reading a variable in its own initializer is an error
+func (r *Resolver) checkInitialized(name *scanner.Token) {
+	if len(r.scopes) == 0 {
+		return
+	}
+	if v, ok := r.scopes[len(r.scopes)-1][name.Lexeme]; ok && !v.ready {
+		r.error(name, "can't read local variable in its own initializer")
+	}
+}
+
+func (r *Resolver) beginScope() {
+	r.scopes = append(r.scopes, map[string]*variable{})
+}
+
+func (r *Resolver) endScope() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+func (r *Resolver) error(token *scanner.Token, msg string) {
+	r.errors = append(r.errors, NewResolveError(token, msg))
+}
+
+// global variables are not tracked, they can be redeclared
+func (r *Resolver) declare(name *scanner.Token) {
+	if len(r.scopes) == 0 {
+		return
+	}
+	scope := r.scopes[len(r.scopes)-1]
+	if _, ok := scope[name.Lexeme]; ok {
+		r.error(name, "already a variable with this name in this scope")
+		scope[name.Lexeme].ready = false
+		return
+	}
+	scope[name.Lexeme] = &variable{len(scope), false}
+}
+
+func (r *Resolver) define(name *scanner.Token) {
+	if len(r.scopes) == 0 {
+		return
+	}
+	r.scopes[len(r.scopes)-1][name.Lexeme].ready = true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+
+	"cjting.me/lox/scanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func resolve(source string) error {
+	tokens, err := scanner.Scan(source)
+	if err != nil {
+		return err
+	}
+	program, err := NewParser().Parse(tokens)
+	if err != nil {
+		return err
+	}
+	return NewResolver().Resolve(program)
+}
+
+func TestResolverErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		line   int
+		msg    string
+	}{
+		{
+			"self initialization",
+			"func a() {\n  var a = a;\n}",
+			2,
+			"can't read local variable in its own initializer",
+		},
+		{
+			"duplicate var",
+			"func b() {\n  var a = 1;\n  var a = 2;\n}",
+			3,
+			"already a variable with this name in this scope",
+		},
+		{
+			"duplicate func",
+			"func b() {\n  func c() {}\n  func c() {}\n}",
+			3,
+			"already a variable with this name in this scope",
+		},
+		{
+			"top level return",
+			"return 100;",
+			1,
+			"can't return from top-level code",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := resolve(test.source)
+			errs, ok := err.(ResolveErrors)
+			if assert.True(t, ok) && assert.Len(t, errs, 1) {
+				assert.Equal(t, test.line, errs[0].token.Line)
+				assert.Equal(t, test.msg, errs[0].msg)
+			}
+		})
+	}
+
+	t.Run("report all errors", func(t *testing.T) {
+		err := resolve("{ var a = 1; var a = 2; }\nreturn;")
+		assert.Len(t, err.(ResolveErrors), 2)
+	})
+
+	t.Run("globals can be redeclared", func(t *testing.T) {
+		assert.Nil(t, resolve("var a = 1; var a = a;"))
+	})
+}
+
+func TestResolverClassErrors(t *testing.T) {
+	tests := map[string]string{
+		"print this;":                                   "can't use 'this' outside of a class",
+		"print super.a;":                                "can't use 'super' outside of a class",
+		"class A { a() { super.a(); } }":                "can't use 'super' in a class with no superclass",
+		"class A < A {}":                                "a class can't inherit from itself",
+		"class A { init() { return 1; } }":              "can't return a value from an initializer",
+		"class A { init() { return; } }":                "",
+		"class A {} class B < A { a() { super.a(); } }": "",
+	}
+
+	for source, msg := range tests {
+		err := resolve(source)
+		if msg == "" {
+			assert.Nil(t, err, source)
+			continue
+		}
+		if errs, ok := err.(ResolveErrors); assert.True(t, ok, source) {
+			assert.Equal(t, msg, errs[0].msg, source)
+		}
+	}
+}
+
+func TestResolverSlots(t *testing.T) {
+	tokens, _ := scanner.Scan("func f(a, b) {\n  var c = 1;\n  { var d = 2; print b + c + d + g; }\n}")
+	program, _ := NewParser().Parse(tokens)
+	assert.Nil(t, NewResolver().Resolve(program))
+
+	// print b + c + d + g;
+	block := program[0].(*StmtFuncDecl).body[1].(*StmtBlock)
+	sum := block.stmts[1].(*StmtPrint).expr.(*ExprBinary)
+	g := sum.right.(*ExprVariable)
+	d := sum.left.(*ExprBinary).right.(*ExprVariable)
+	c := sum.left.(*ExprBinary).left.(*ExprBinary).right.(*ExprVariable)
+	b := sum.left.(*ExprBinary).left.(*ExprBinary).left.(*ExprVariable)
+
+	assert.Equal(t, []int{1, 1}, []int{b.depth, b.slot})
+	assert.Equal(t, []int{1, 2}, []int{c.depth, c.slot})
+	assert.Equal(t, []int{0, 0}, []int{d.depth, d.slot})
+	assert.Equal(t, -1, g.depth)
+}
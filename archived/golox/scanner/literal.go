@@ -1,11 +1,20 @@
 package scanner
 
-import "strconv"
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
 
 type Number = float64
 
-func parseStringLiteral(input string) string {
-	return input
+// hex is the digits of a `\x` or `\u{}` escape sequence
+func parseCodePoint(hex string) (rune, error) {
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || !utf8.ValidRune(rune(n)) {
+		return 0, fmt.Errorf("invalid escape sequence, '%s' is not a valid unicode code point", hex)
+	}
+	return rune(n), nil
 }
 
 func parseNumberLiteral(input string) (Number, error) {
@@ -2,6 +2,7 @@ package scanner
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -11,6 +12,9 @@ type scanner struct {
 	start  int
 	next   int
 	line   int
+	// one entry for every unfinished `${` in strings, the entry is
+	// how many `{` are open inside the interpolation
+	interpolations []int
 }
 
 func Scan(source string) ([]*Token, error) {
@@ -36,6 +40,10 @@ func (s *scanner) scan(source string) ([]*Token, error) {
 		}
 	}
 
+	if len(s.interpolations) > 0 {
+		return nil, fmt.Errorf("line %d, unterminated string interpolation", s.line)
+	}
+
 	tokens = append(
 		tokens,
 		NewToken(EOF, "", nil, s.line),
@@ -49,6 +57,7 @@ func (s *scanner) reset(source string) {
 	s.start = 0
 	s.next = 0
 	s.line = 1
+	s.interpolations = nil
 }
 
 func (s *scanner) isAtEnd() bool {
@@ -88,12 +97,34 @@ func (s *scanner) newToken(typ TokenType, literal interface{}) *Token {
 	)
 }
 
+// "a ${b} c" is scanned as INTERPOLATION("a "), IDENTIFIER(b), STRING(" c"),
+// a string with several interpolations has several INTERPOLATION tokens,
+// the part after `}` is scanned when the matching `}` is met
 func (s *scanner) scanString() (*Token, error) {
+	value := &strings.Builder{}
+
 	for s.peek() != '"' && !s.isAtEnd() {
-		if s.peek() == '\n' {
+		c := s.advance()
+
+		if c == '$' && s.peek() == '{' {
+			s.advance()
+			s.interpolations = append(s.interpolations, 0)
+			return s.newToken(INTERPOLATION, value.String()), nil
+		}
+
+		if c == '\n' {
 			s.line++
 		}
-		s.advance()
+
+		if c == '\\' {
+			r, err := s.scanEscape()
+			if err != nil {
+				return nil, err
+			}
+			c = r
+		}
+
+		value.WriteRune(c)
 	}
 
 	if s.isAtEnd() {
@@ -103,7 +134,62 @@ func (s *scanner) scanString() (*Token, error) {
 	// swallow the closing "
 	s.advance()
 
-	return s.newToken(STRING, parseStringLiteral(string(s.source[s.start+1:s.next-1]))), nil
+	return s.newToken(STRING, value.String()), nil
+}
+
+// the backslash is consumed
+func (s *scanner) scanEscape() (rune, error) {
+	if s.isAtEnd() {
+		return 0, fmt.Errorf("unterminated string")
+	}
+
+	c := s.advance()
+	switch c {
+	case 'n':
+		return '\n', nil
+	case 't':
+		return '\t', nil
+	case 'r':
+		return '\r', nil
+	case '0':
+		return 0, nil
+	case '\\':
+		return '\\', nil
+	case '"':
+		return '"', nil
+	case '$':
+		return '$', nil
+	case 'x':
+		// \xHH
+		digits := ""
+		for i := 0; i < 2 && isHexDigit(s.peek()); i++ {
+			digits += string(s.advance())
+		}
+		if len(digits) != 2 {
+			return 0, fmt.Errorf("invalid escape sequence, '\\x' expects 2 hex digits")
+		}
+		return parseCodePoint(digits)
+	case 'u':
+		// \u{H...}, 1 to 6 hex digits
+		if s.peek() != '{' {
+			return 0, fmt.Errorf("invalid escape sequence, expect '{' after '\\u'")
+		}
+		s.advance()
+		digits := ""
+		for isHexDigit(s.peek()) {
+			digits += string(s.advance())
+		}
+		if s.peek() != '}' {
+			return 0, fmt.Errorf("invalid escape sequence, expect '}' after unicode code point")
+		}
+		s.advance()
+		if len(digits) == 0 || len(digits) > 6 {
+			return 0, fmt.Errorf("invalid escape sequence, '\\u{...}' expects 1 to 6 hex digits")
+		}
+		return parseCodePoint(digits)
+	}
+
+	return 0, fmt.Errorf("invalid escape sequence '\\%c'", c)
 }
 
 func (s *scanner) scanNumber() (*Token, error) {
@@ -182,21 +268,84 @@ func (s *scanner) scanToken() (*Token, error) {
 	case ')':
 		token = s.newToken(RIGHT_PAREN, nil)
 	case '{':
+		if n := len(s.interpolations); n > 0 {
+			s.interpolations[n-1]++
+		}
 		token = s.newToken(LEFT_BRACE, nil)
 	case '}':
+		if n := len(s.interpolations); n > 0 {
+			// end of interpolation, continue to scan the string
+			if s.interpolations[n-1] == 0 {
+				s.interpolations = s.interpolations[:n-1]
+				return s.scanString()
+			}
+			s.interpolations[n-1]--
+		}
 		token = s.newToken(RIGHT_BRACE, nil)
+	case '[':
+		token = s.newToken(LEFT_BRACKET, nil)
+	case ']':
+		token = s.newToken(RIGHT_BRACKET, nil)
 	case ',':
 		token = s.newToken(COMMA, nil)
 	case '-':
-		token = s.newToken(MINUS, nil)
+		if s.peek() == '-' {
+			s.advance()
+			token = s.newToken(MINUS_MINUS, nil)
+		} else if s.peek() == '=' {
+			s.advance()
+			token = s.newToken(MINUS_EQUAL, nil)
+		} else {
+			token = s.newToken(MINUS, nil)
+		}
 	case '+':
-		token = s.newToken(PLUS, nil)
+		if s.peek() == '+' {
+			s.advance()
+			token = s.newToken(PLUS_PLUS, nil)
+		} else if s.peek() == '=' {
+			s.advance()
+			token = s.newToken(PLUS_EQUAL, nil)
+		} else {
+			token = s.newToken(PLUS, nil)
+		}
 	case ';':
 		token = s.newToken(SEMICOLON, nil)
+	case '%':
+		if s.peek() == '=' {
+			s.advance()
+			token = s.newToken(PERCENT_EQUAL, nil)
+		} else {
+			token = s.newToken(PERCENT, nil)
+		}
 	case '*':
-		token = s.newToken(STAR, nil)
+		if s.peek() == '*' {
+			s.advance()
+			token = s.newToken(STAR_STAR, nil)
+		} else if s.peek() == '=' {
+			s.advance()
+			token = s.newToken(STAR_EQUAL, nil)
+		} else {
+			token = s.newToken(STAR, nil)
+		}
+	case '&':
+		token = s.newToken(AMPERSAND, nil)
+	case '|':
+		token = s.newToken(PIPE, nil)
+	case '^':
+		token = s.newToken(CARET, nil)
+	case '~':
+		if s.peek() == '/' {
+			s.advance()
+			token = s.newToken(TILDE_SLASH, nil)
+		} else {
+			token = s.newToken(TILDE, nil)
+		}
 	case '.':
 		token = s.newToken(DOT, nil)
+	case '?':
+		token = s.newToken(QUESTION, nil)
+	case ':':
+		token = s.newToken(COLON, nil)
 
 	case '!':
 		if s.peek() == '=' {
@@ -216,6 +365,9 @@ func (s *scanner) scanToken() (*Token, error) {
 		if s.peek() == '=' {
 			s.advance()
 			token = s.newToken(LESS_EQUAL, nil)
+		} else if s.peek() == '<' {
+			s.advance()
+			token = s.newToken(LESS_LESS, nil)
 		} else {
 			token = s.newToken(LESS, nil)
 		}
@@ -223,6 +375,9 @@ func (s *scanner) scanToken() (*Token, error) {
 		if s.peek() == '=' {
 			s.advance()
 			token = s.newToken(GREATER_EQUAL, nil)
+		} else if s.peek() == '>' {
+			s.advance()
+			token = s.newToken(GREATER_GREATER, nil)
 		} else {
 			token = s.newToken(GREATER, nil)
 		}
@@ -236,6 +391,9 @@ func (s *scanner) scanToken() (*Token, error) {
 		} else if s.peek() == '*' {
 			s.advance() // consume *
 			s.scanBlockComment()
+		} else if s.peek() == '=' {
+			s.advance()
+			token = s.newToken(SLASH_EQUAL, nil)
 		} else {
 			token = s.newToken(SLASH, nil)
 		}
@@ -270,6 +428,10 @@ func isDigit(r rune) bool {
 	return r >= '0' && r <= '9'
 }
 
+func isHexDigit(r rune) bool {
+	return isDigit(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
 func isAlpha(c rune) bool {
 	return (c >= 'a' && c <= 'z') ||
 		(c >= 'A' && c <= 'Z') ||
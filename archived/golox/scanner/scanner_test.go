@@ -8,10 +8,11 @@ import (
 
 func TestScannerOverall(t *testing.T) {
 	assert := assert.New(t)
-	src := `( ) { } , . - + ; / *
-  ! != = == > >= < <=
+	src := `( ) { } [ ] , . - + ; / * ? : % & | ^
+  ! != = == > >= < <= ** ~ ~/ << >> += -= *= /= %= ++ --
   identifier "string" 1.234
   and class else func for if nil or print return super this true false var while
+  break continue throw try catch finally import as switch case default in
 `
 	tokens, err := Scan(src)
 
@@ -22,6 +23,8 @@ func TestScannerOverall(t *testing.T) {
 		{RIGHT_PAREN, ")", nil, 1},
 		{LEFT_BRACE, "{", nil, 1},
 		{RIGHT_BRACE, "}", nil, 1},
+		{LEFT_BRACKET, "[", nil, 1},
+		{RIGHT_BRACKET, "]", nil, 1},
 		{COMMA, ",", nil, 1},
 		{DOT, ".", nil, 1},
 		{MINUS, "-", nil, 1},
@@ -29,6 +32,12 @@ func TestScannerOverall(t *testing.T) {
 		{SEMICOLON, ";", nil, 1},
 		{SLASH, "/", nil, 1},
 		{STAR, "*", nil, 1},
+		{QUESTION, "?", nil, 1},
+		{COLON, ":", nil, 1},
+		{PERCENT, "%", nil, 1},
+		{AMPERSAND, "&", nil, 1},
+		{PIPE, "|", nil, 1},
+		{CARET, "^", nil, 1},
 		{BANG, "!", nil, 2},
 		{BANG_EQUAL, "!=", nil, 2},
 		{EQUAL, "=", nil, 2},
@@ -37,6 +46,18 @@ func TestScannerOverall(t *testing.T) {
 		{GREATER_EQUAL, ">=", nil, 2},
 		{LESS, "<", nil, 2},
 		{LESS_EQUAL, "<=", nil, 2},
+		{STAR_STAR, "**", nil, 2},
+		{TILDE, "~", nil, 2},
+		{TILDE_SLASH, "~/", nil, 2},
+		{LESS_LESS, "<<", nil, 2},
+		{GREATER_GREATER, ">>", nil, 2},
+		{PLUS_EQUAL, "+=", nil, 2},
+		{MINUS_EQUAL, "-=", nil, 2},
+		{STAR_EQUAL, "*=", nil, 2},
+		{SLASH_EQUAL, "/=", nil, 2},
+		{PERCENT_EQUAL, "%=", nil, 2},
+		{PLUS_PLUS, "++", nil, 2},
+		{MINUS_MINUS, "--", nil, 2},
 		{IDENTIFIER, "identifier", nil, 3},
 		{STRING, `"string"`, "string", 3},
 		{NUMBER, "1.234", 1.234, 3},
@@ -56,7 +77,19 @@ func TestScannerOverall(t *testing.T) {
 		{FALSE, "false", nil, 4},
 		{VAR, "var", nil, 4},
 		{WHILE, "while", nil, 4},
-		{EOF, "", nil, 5},
+		{BREAK, "break", nil, 5},
+		{CONTINUE, "continue", nil, 5},
+		{THROW, "throw", nil, 5},
+		{TRY, "try", nil, 5},
+		{CATCH, "catch", nil, 5},
+		{FINALLY, "finally", nil, 5},
+		{IMPORT, "import", nil, 5},
+		{AS, "as", nil, 5},
+		{SWITCH, "switch", nil, 5},
+		{CASE, "case", nil, 5},
+		{DEFAULT, "default", nil, 5},
+		{IN, "in", nil, 5},
+		{EOF, "", nil, 6},
 	}
 
 	for i := range tokens {
@@ -106,3 +139,76 @@ func TestScannerComment(t *testing.T) {
 		assert.Equal(t, expected, tokens[0])
 	})
 }
+
+func TestScannerStringEscape(t *testing.T) {
+	t.Run("valid escapes", func(t *testing.T) {
+		tests := map[string]string{
+			`"a\nb"`:           "a\nb",
+			`"a\tb\rc"`:        "a\tb\rc",
+			`"\\ \""`:          `\ "`,
+			`"a\0b"`:           "a\x00b",
+			`"\x41\x7e"`:       "A~",
+			`"\u{48}\u{4F60}"`: "H你",
+			`"\u{1F600}"`:      "😀",
+		}
+		for src, expected := range tests {
+			tokens, err := Scan(src)
+			if assert.Nil(t, err, src) {
+				assert.Equal(t, NewToken(STRING, src, expected, 1), tokens[0], src)
+			}
+		}
+	})
+
+	t.Run("invalid escapes", func(t *testing.T) {
+		tests := map[string]string{
+			`"\q"`:          `line 1, invalid escape sequence '\q'`,
+			"\"a\n\n\\x4\"": `line 3, invalid escape sequence, '\x' expects 2 hex digits`,
+			`"\u41"`:        `line 1, invalid escape sequence, expect '{' after '\u'`,
+			`"\u{41"`:       `line 1, invalid escape sequence, expect '}' after unicode code point`,
+			`"\u{}"`:        `line 1, invalid escape sequence, '\u{...}' expects 1 to 6 hex digits`,
+			`"\u{D800}"`:    `line 1, invalid escape sequence, 'D800' is not a valid unicode code point`,
+			`"\u{110000}"`:  `line 1, invalid escape sequence, '110000' is not a valid unicode code point`,
+			`"abc\`:         `line 1, unterminated string`,
+		}
+		for src, msg := range tests {
+			_, err := Scan(src)
+			assert.EqualError(t, err, msg, src)
+		}
+	})
+}
+
+func TestScannerInterpolation(t *testing.T) {
+	t.Run("tokens", func(t *testing.T) {
+		tokens, err := Scan(`"a ${b} c ${ {} } \${d}"`)
+		assert.Nil(t, err)
+		assert.Equal(t, []*Token{
+			{INTERPOLATION, `"a ${`, "a ", 1},
+			{IDENTIFIER, "b", nil, 1},
+			{INTERPOLATION, `} c ${`, " c ", 1},
+			{LEFT_BRACE, "{", nil, 1},
+			{RIGHT_BRACE, "}", nil, 1},
+			{STRING, `} \${d}"`, " ${d}", 1},
+			{EOF, "", nil, 1},
+		}, tokens)
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		tokens, err := Scan(`"a ${"b ${c}"}"`)
+		assert.Nil(t, err)
+		var types []TokenType
+		for _, token := range tokens {
+			types = append(types, token.Type)
+		}
+		assert.Equal(t, []TokenType{
+			INTERPOLATION, INTERPOLATION, IDENTIFIER, STRING, STRING, EOF,
+		}, types)
+	})
+
+	t.Run("unterminated", func(t *testing.T) {
+		_, err := Scan(`"a ${b"`)
+		assert.EqualError(t, err, "line 1, unterminated string")
+
+		_, err = Scan(`"a ${b`)
+		assert.EqualError(t, err, "line 1, unterminated string interpolation")
+	})
+}
@@ -6,50 +6,84 @@ type TokenType string
 
 const (
 	// Single-character tokens
-	LEFT_PAREN  TokenType = "Left_Paren"  // (
-	RIGHT_PAREN           = "Right_Paren" // )
-	LEFT_BRACE            = "Left_Brace"  // {
-	RIGHT_BRACE           = "Right_Brace" // }
-	COMMA                 = "Comma"       // ,
-	DOT                   = "Dot"         // .
-	MINUS                 = "Minus"       // -
-	PLUS                  = "Plus"        // +
-	SEMICOLON             = "Semicolon"   // ;
-	SLASH                 = "Slash"       // /
-	STAR                  = "Star"        // *
+	LEFT_PAREN    TokenType = "Left_Paren"    // (
+	RIGHT_PAREN             = "Right_Paren"   // )
+	LEFT_BRACE              = "Left_Brace"    // {
+	RIGHT_BRACE             = "Right_Brace"   // }
+	LEFT_BRACKET            = "Left_Bracket"  // [
+	RIGHT_BRACKET           = "Right_Bracket" // ]
+	COMMA                   = "Comma"         // ,
+	DOT                     = "Dot"           // .
+	MINUS                   = "Minus"         // -
+	PLUS                    = "Plus"          // +
+	SEMICOLON               = "Semicolon"     // ;
+	SLASH                   = "Slash"         // /
+	STAR                    = "Star"          // *
+	PERCENT                 = "Percent"       // %
+	AMPERSAND               = "Ampersand"     // &
+	PIPE                    = "Pipe"          // |
+	CARET                   = "Caret"         // ^
+	QUESTION                = "Question"      // ?
+	COLON                   = "Colon"         // :
 
 	// One or two character tokens
-	BANG          = "Bang"          // !
-	BANG_EQUAL    = "Bang_Equal"    // !=
-	EQUAL         = "Equal"         // =
-	EQUAL_EQUAL   = "Equal_Equal"   // ==
-	GREATER       = "Greater"       // >
-	GREATER_EQUAL = "Greater_Equal" // >=
-	LESS          = "Less"          // <
-	LESS_EQUAL    = "Less_Equal"    // <=
+	BANG            = "Bang"            // !
+	BANG_EQUAL      = "Bang_Equal"      // !=
+	EQUAL           = "Equal"           // =
+	EQUAL_EQUAL     = "Equal_Equal"     // ==
+	GREATER         = "Greater"         // >
+	GREATER_EQUAL   = "Greater_Equal"   // >=
+	LESS            = "Less"            // <
+	LESS_EQUAL      = "Less_Equal"      // <=
+	STAR_STAR       = "Star_Star"       // **
+	TILDE           = "Tilde"           // ~
+	TILDE_SLASH     = "Tilde_Slash"     // ~/
+	LESS_LESS       = "Less_Less"       // <<
+	GREATER_GREATER = "Greater_Greater" // >>
+	PLUS_EQUAL      = "Plus_Equal"      // +=
+	MINUS_EQUAL     = "Minus_Equal"     // -=
+	STAR_EQUAL      = "Star_Equal"      // *=
+	SLASH_EQUAL     = "Slash_Equal"     // /=
+	PERCENT_EQUAL   = "Percent_Equal"   // %=
+	PLUS_PLUS       = "Plus_Plus"       // ++
+	MINUS_MINUS     = "Minus_Minus"     // --
 
 	// Literals
 	IDENTIFIER = "Identifier"
 	STRING     = "String"
 	NUMBER     = "Number"
+	// string part before `${`
+	INTERPOLATION = "Interpolation"
 
 	// Keywords
-	AND    = "And"
-	CLASS  = "Class"
-	ELSE   = "Else"
-	FUNC   = "Func"
-	FOR    = "For"
-	IF     = "If"
-	NIL    = "Nil"
-	OR     = "Or"
-	PRINT  = "Print"
-	RETURN = "Return"
-	SUPER  = "Super"
-	THIS   = "This"
-	TRUE   = "True"
-	FALSE  = "False"
-	VAR    = "Var"
-	WHILE  = "While"
+	AND      = "And"
+	AS       = "As"
+	BREAK    = "Break"
+	CASE     = "Case"
+	CATCH    = "Catch"
+	CLASS    = "Class"
+	CONTINUE = "Continue"
+	DEFAULT  = "Default"
+	ELSE     = "Else"
+	FINALLY  = "Finally"
+	FUNC     = "Func"
+	FOR      = "For"
+	IF       = "If"
+	IMPORT   = "Import"
+	IN       = "In"
+	NIL      = "Nil"
+	OR       = "Or"
+	PRINT    = "Print"
+	RETURN   = "Return"
+	SUPER    = "Super"
+	SWITCH   = "Switch"
+	THIS     = "This"
+	THROW    = "Throw"
+	TRUE     = "True"
+	TRY      = "Try"
+	FALSE    = "False"
+	VAR      = "Var"
+	WHILE    = "While"
 
 	EOF = "EOF"
 )
@@ -66,22 +100,34 @@ func (t *Token) String() string {
 }
 
 var keyworkdTokens = map[string]TokenType{
-	"and":    AND,
-	"class":  CLASS,
-	"else":   ELSE,
-	"false":  FALSE,
-	"for":    FOR,
-	"func":   FUNC,
-	"if":     IF,
-	"nil":    NIL,
-	"or":     OR,
-	"print":  PRINT,
-	"return": RETURN,
-	"super":  SUPER,
-	"this":   THIS,
-	"true":   TRUE,
-	"var":    VAR,
-	"while":  WHILE,
+	"and":      AND,
+	"as":       AS,
+	"break":    BREAK,
+	"case":     CASE,
+	"catch":    CATCH,
+	"class":    CLASS,
+	"continue": CONTINUE,
+	"default":  DEFAULT,
+	"else":     ELSE,
+	"false":    FALSE,
+	"finally":  FINALLY,
+	"for":      FOR,
+	"func":     FUNC,
+	"if":       IF,
+	"import":   IMPORT,
+	"in":       IN,
+	"nil":      NIL,
+	"or":       OR,
+	"print":    PRINT,
+	"return":   RETURN,
+	"super":    SUPER,
+	"switch":   SWITCH,
+	"this":     THIS,
+	"throw":    THROW,
+	"true":     TRUE,
+	"try":      TRY,
+	"var":      VAR,
+	"while":    WHILE,
 }
 
 func NewToken(
@@ -1,9 +1,15 @@
 package main
 
-import "cjting.me/lox/scanner"
+import (
+	"bytes"
+	"strings"
+
+	"cjting.me/lox/scanner"
+)
 
 type Stmt interface {
-	Run(env *Env)
+	Print() string // for debug
+	Run(env *Env) Completion
 }
 
 /*----------  Print Stmt  ----------*/
@@ -16,6 +22,10 @@ func NewStmtPrint(expr Expr) *StmtPrint {
 	return &StmtPrint{expr}
 }
 
+func (s *StmtPrint) Print() string {
+	return parenthesize("print", s.expr)
+}
+
 /*----------  Expression Stmt  ----------*/
 
 type StmtExpression struct {
@@ -26,6 +36,10 @@ func NewStmtExpression(expr Expr) *StmtExpression {
 	return &StmtExpression{expr}
 }
 
+func (s *StmtExpression) Print() string {
+	return parenthesize(";", s.expr)
+}
+
 /*----------  Var Decl Stmt  ----------*/
 type StmtVarDecl struct {
 	name  *scanner.Token
@@ -36,6 +50,13 @@ func NewStmtVarDecl(name *scanner.Token, value Expr) *StmtVarDecl {
 	return &StmtVarDecl{name, value}
 }
 
+func (s *StmtVarDecl) Print() string {
+	if s.value == nil {
+		return parenthesize("var " + s.name.Lexeme)
+	}
+	return parenthesize("var "+s.name.Lexeme, s.value)
+}
+
 /*----------  Block Stmt  ----------*/
 type StmtBlock struct {
 	stmts []Stmt
@@ -45,6 +66,10 @@ func NewStmtBlock(stmts []Stmt) *StmtBlock {
 	return &StmtBlock{stmts}
 }
 
+func (s *StmtBlock) Print() string {
+	return parenthesizeStmts("block", s.stmts...)
+}
+
 /*----------  If Stmt  ----------*/
 type StmtIf struct {
 	condition   Expr
@@ -56,27 +81,81 @@ func NewStmtIf(condition Expr, trueBranch, falseBranch Stmt) *StmtIf {
 	return &StmtIf{condition, trueBranch, falseBranch}
 }
 
+func (s *StmtIf) Print() string {
+	if s.falseBranch == nil {
+		return sprintf("(if %s %s)", s.condition.Print(), s.trueBranch.Print())
+	}
+	return sprintf("(if %s %s %s)", s.condition.Print(), s.trueBranch.Print(), s.falseBranch.Print())
+}
+
 /*----------  While Stmt  ----------*/
 type StmtWhile struct {
 	condition Expr
 	body      Stmt
+	// for loop's increment, runs after body even if body `continue`s
+	increment Expr
+}
+
+func NewStmtWhile(condition Expr, body Stmt, increment Expr) *StmtWhile {
+	return &StmtWhile{condition, body, increment}
+}
+
+func (s *StmtWhile) Print() string {
+	if s.increment == nil {
+		return sprintf("(while %s %s)", s.condition.Print(), s.body.Print())
+	}
+	return sprintf("(while %s %s %s)", s.condition.Print(), s.body.Print(), s.increment.Print())
+}
+
+/*----------  Break Stmt  ----------*/
+type StmtBreak struct {
+	keyword *scanner.Token
+}
+
+func NewStmtBreak(keyword *scanner.Token) *StmtBreak {
+	return &StmtBreak{keyword}
 }
 
-func NewStmtWhile(condition Expr, body Stmt) *StmtWhile {
-	return &StmtWhile{condition, body}
+func (s *StmtBreak) Print() string {
+	return "(break)"
+}
+
+/*----------  Continue Stmt  ----------*/
+type StmtContinue struct {
+	keyword *scanner.Token
+}
+
+func NewStmtContinue(keyword *scanner.Token) *StmtContinue {
+	return &StmtContinue{keyword}
+}
+
+func (s *StmtContinue) Print() string {
+	return "(continue)"
 }
 
 /*----------  Function Declaration Stmt  ----------*/
 type StmtFuncDecl struct {
+	// nil for anonymous function
 	name       *scanner.Token
 	parameters []*scanner.Token
 	body       []Stmt
-	// 运行时赋值
-	closure *Env
 }
 
 func NewStmtFuncDecl(name *scanner.Token, parameters []*scanner.Token, body []Stmt) *StmtFuncDecl {
-	return &StmtFuncDecl{name, parameters, body, nil}
+	return &StmtFuncDecl{name, parameters, body}
+}
+
+// (func name (a b) body...)
+func (s *StmtFuncDecl) Print() string {
+	var params []string
+	for _, param := range s.parameters {
+		params = append(params, param.Lexeme)
+	}
+	head := "func (" + strings.Join(params, " ") + ")"
+	if s.name != nil {
+		head = "func " + s.name.Lexeme + " (" + strings.Join(params, " ") + ")"
+	}
+	return parenthesizeStmts(head, s.body...)
 }
 
 /*----------  Return Stmt  ----------*/
@@ -88,3 +167,173 @@ type StmtReturn struct {
 func NewStmtReturn(token *scanner.Token, value Expr) *StmtReturn {
 	return &StmtReturn{token, value}
 }
+
+func (s *StmtReturn) Print() string {
+	if s.value == nil {
+		return "(return)"
+	}
+	return parenthesize("return", s.value)
+}
+
+/*----------  Class Declaration Stmt  ----------*/
+type StmtClassDecl struct {
+	name       *scanner.Token
+	superclass *ExprVariable
+	methods    []*StmtFuncDecl
+}
+
+func NewStmtClassDecl(name *scanner.Token, superclass *ExprVariable, methods []*StmtFuncDecl) *StmtClassDecl {
+	return &StmtClassDecl{name, superclass, methods}
+}
+
+func (s *StmtClassDecl) Print() string {
+	head := "class " + s.name.Lexeme
+	if s.superclass != nil {
+		head += " < " + s.superclass.name.Lexeme
+	}
+	var methods []Stmt
+	for _, method := range s.methods {
+		methods = append(methods, method)
+	}
+	return parenthesizeStmts(head, methods...)
+}
+
+/*----------  Throw Stmt  ----------*/
+type StmtThrow struct {
+	keyword *scanner.Token
+	value   Expr
+}
+
+func NewStmtThrow(keyword *scanner.Token, value Expr) *StmtThrow {
+	return &StmtThrow{keyword, value}
+}
+
+func (s *StmtThrow) Print() string {
+	return parenthesize("throw", s.value)
+}
+
+/*----------  Try Stmt  ----------*/
+type StmtTry struct {
+	body *StmtBlock
+	// nil if there is no catch clause
+	catchName *scanner.Token
+	catchBody []Stmt
+	// nil if there is no finally clause
+	finallyBody *StmtBlock
+}
+
+func NewStmtTry(body *StmtBlock, catchName *scanner.Token, catchBody []Stmt, finallyBody *StmtBlock) *StmtTry {
+	return &StmtTry{body, catchName, catchBody, finallyBody}
+}
+
+// (try (block ...) (catch e ...) (finally ...))
+func (s *StmtTry) Print() string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("(try ")
+	buf.WriteString(s.body.Print())
+	if s.catchName != nil {
+		buf.WriteString(" ")
+		buf.WriteString(parenthesizeStmts("catch "+s.catchName.Lexeme, s.catchBody...))
+	}
+	if s.finallyBody != nil {
+		buf.WriteString(" ")
+		buf.WriteString(parenthesizeStmts("finally", s.finallyBody.stmts...))
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
+
+/*----------  For In Stmt  ----------*/
+
+// `for (var name in iterable) body`, name is bound freshly
+// in every iteration
+type StmtForIn struct {
+	name     *scanner.Token
+	in       *scanner.Token
+	iterable Expr
+	body     Stmt
+}
+
+func NewStmtForIn(name, in *scanner.Token, iterable Expr, body Stmt) *StmtForIn {
+	return &StmtForIn{name, in, iterable, body}
+}
+
+func (s *StmtForIn) Print() string {
+	return sprintf("(for-in %s %s %s)", s.name.Lexeme, s.iterable.Print(), s.body.Print())
+}
+
+/*----------  Switch Stmt  ----------*/
+type StmtSwitch struct {
+	keyword *scanner.Token
+	value   Expr
+	cases   []*SwitchCase
+	// nil if there is no default clause
+	defaultBody *StmtBlock
+}
+
+// `case 1, 2: ...`, body runs if value equals any of values
+type SwitchCase struct {
+	values []Expr
+	body   *StmtBlock
+}
+
+func NewStmtSwitch(keyword *scanner.Token, value Expr, cases []*SwitchCase, defaultBody *StmtBlock) *StmtSwitch {
+	return &StmtSwitch{keyword, value, cases, defaultBody}
+}
+
+func NewSwitchCase(values []Expr, body *StmtBlock) *SwitchCase {
+	return &SwitchCase{values, body}
+}
+
+// (switch x (case (1 2) ...) (default ...))
+func (s *StmtSwitch) Print() string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("(switch ")
+	buf.WriteString(s.value.Print())
+	for _, c := range s.cases {
+		var values []string
+		for _, value := range c.values {
+			values = append(values, value.Print())
+		}
+		buf.WriteString(" ")
+		buf.WriteString(parenthesizeStmts("case ("+strings.Join(values, " ")+")", c.body.stmts...))
+	}
+	if s.defaultBody != nil {
+		buf.WriteString(" ")
+		buf.WriteString(parenthesizeStmts("default", s.defaultBody.stmts...))
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
+
+/*----------  Import Stmt  ----------*/
+type StmtImport struct {
+	keyword *scanner.Token
+	// string literal of module path
+	path *scanner.Token
+	name *scanner.Token
+}
+
+func NewStmtImport(keyword, path, name *scanner.Token) *StmtImport {
+	return &StmtImport{keyword, path, name}
+}
+
+func (s *StmtImport) Print() string {
+	return sprintf("(import %s %s)", s.path.Lexeme, s.name.Lexeme)
+}
+
+/*----------  Helper Methods  ----------*/
+
+func parenthesizeStmts(name string, stmts ...Stmt) string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("(")
+	buf.WriteString(name)
+
+	for _, stmt := range stmts {
+		buf.WriteString(" ")
+		buf.WriteString(stmt.Print())
+	}
+	buf.WriteString(")")
+
+	return buf.String()
+}
@@ -1,5 +1,12 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+)
 
 var sprintf = fmt.Sprintf
+
+// where `print` writes to, replaced in tests
+var stdout io.Writer = os.Stdout
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"cjting.me/lox/scanner"
+)
+
+// VM runs functions produced by Compiler on a value stack,
+// runtime errors unwind with panic like in the tree-walking interpreter
+type VM struct {
+	stack  []Val
+	frames []CallFrame
+	// installed by OP_TRY and OP_TRY_FINALLY, innermost last
+	handlers []handler
+	// upvalues still pointing to the stack
+	openUpvalues []*VMUpvalue
+	// more nested calls is a stack overflow
+	maxCallDepth int
+}
+
+type CallFrame struct {
+	closure *VMClosure
+	ip      int
+	// stack index of slot 0
+	base int
+}
+
+// where to continue when an error is raised
+type handler struct {
+	frame int
+	ip    int
+	// stack height when the handler is installed
+	sp int
+	// finally handler gets the raw error to throw it again,
+	// catch handler gets the value seen by Lox code
+	finally bool
+}
+
+func NewVM(maxCallDepth int) *VM {
+	return &VM{maxCallDepth: maxCallDepth}
+}
+
+// run the top-level function of a module, return the value it returns,
+// which is the value of the expression for functions from CompileExpression
+func (vm *VM) Interpret(function *VMFunction) (val Val, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch re := e.(type) {
+			case *RuntimeError:
+				err = re
+			case *Throw:
+				err = re
+			default:
+				panic(e)
+			}
+		}
+	}()
+
+	closure := NewVMClosure(function)
+	vm.push(closure)
+	vm.frames = append(vm.frames, CallFrame{closure, 0, 0})
+	for {
+		if val, done := vm.execute(); done {
+			return val, nil
+		}
+	}
+}
+
+// execute instructions until the top frame returns, an error caught by
+// a handler stops the execution to unwind the stack, caller starts again
+func (vm *VM) execute() (result Val, done bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			if !vm.handle(e) {
+				panic(e)
+			}
+		}
+	}()
+
+	frame := &vm.frames[len(vm.frames)-1]
+	chunk := frame.closure.function.chunk
+
+	readByte := func() byte {
+		b := chunk.code[frame.ip]
+		frame.ip++
+		return b
+	}
+	readShort := func() int {
+		n := chunk.readShort(frame.ip)
+		frame.ip += 2
+		return n
+	}
+	readString := func() string {
+		return chunk.constants[readShort()].(string)
+	}
+	// token of the current instruction, used to report runtime errors
+	token := func(lexeme string) *scanner.Token {
+		return &scanner.Token{Lexeme: lexeme, Line: chunk.line(frame.ip - 1)}
+	}
+	// call stack changed
+	refresh := func() {
+		frame = &vm.frames[len(vm.frames)-1]
+		chunk = frame.closure.function.chunk
+	}
+
+	for {
+		op := OpCode(readByte())
+		switch op {
+		case OP_CONSTANT:
+			vm.push(chunk.constants[readShort()])
+		case OP_NIL:
+			vm.push(nil)
+		case OP_TRUE:
+			vm.push(true)
+		case OP_FALSE:
+			vm.push(false)
+		case OP_POP:
+			vm.pop()
+		case OP_DUP:
+			vm.push(vm.peek(0))
+		case OP_GET_LOCAL:
+			vm.push(vm.stack[frame.base+int(readByte())])
+		case OP_SET_LOCAL:
+			vm.stack[frame.base+int(readByte())] = vm.peek(0)
+		case OP_GET_GLOBAL:
+			name := readString()
+			val, ok := frame.closure.function.module.env.globals[name]
+			if !ok {
+				panic(NewRuntimeError(token(name), sprintf("undefined variable '%s'", name)))
+			}
+			vm.push(val)
+		case OP_DEFINE_GLOBAL:
+			frame.closure.function.module.env.globals[readString()] = vm.pop()
+		case OP_SET_GLOBAL:
+			name := readString()
+			globals := frame.closure.function.module.env.globals
+			if _, ok := globals[name]; !ok {
+				panic(NewRuntimeError(token(name), sprintf("undefined variable '%s'", name)))
+			}
+			globals[name] = vm.peek(0)
+		case OP_GET_UPVALUE:
+			upvalue := frame.closure.upvalues[readByte()]
+			if upvalue.open {
+				vm.push(vm.stack[upvalue.slot])
+			} else {
+				vm.push(upvalue.closed)
+			}
+		case OP_SET_UPVALUE:
+			upvalue := frame.closure.upvalues[readByte()]
+			if upvalue.open {
+				vm.stack[upvalue.slot] = vm.peek(0)
+			} else {
+				upvalue.closed = vm.peek(0)
+			}
+		case OP_GET_PROPERTY:
+			name := token(readString())
+			var val Val
+			switch obj := vm.pop().(type) {
+			case *VMInstance:
+				val = obj.Get(name)
+			case *LoxError:
+				val = obj.Get(name)
+			case *LoxModule:
+				val = obj.Get(name)
+			default:
+				panic(NewRuntimeError(name, "only instances have properties"))
+			}
+			vm.push(val)
+		case OP_SET_PROPERTY:
+			name := readString()
+			val := vm.pop()
+			instance, ok := vm.pop().(*VMInstance)
+			if !ok {
+				panic(NewRuntimeError(token(name), "only instances have fields"))
+			}
+			instance.fields[name] = val
+			vm.push(val)
+		case OP_GET_SUPER:
+			name := readString()
+			superclass := vm.pop().(*VMClass)
+			receiver := vm.pop()
+			method, ok := superclass.methods[name]
+			if !ok {
+				panic(NewRuntimeError(token(name), sprintf("undefined property '%s'", name)))
+			}
+			vm.push(NewVMBoundMethod(receiver, method))
+		case OP_EQUAL:
+			right := vm.pop()
+			vm.push(vm.pop() == right)
+		case OP_NOT_EQUAL:
+			right := vm.pop()
+			vm.push(vm.pop() != right)
+		case OP_GREATER, OP_GREATER_EQUAL, OP_LESS, OP_LESS_EQUAL,
+			OP_ADD, OP_SUBTRACT, OP_MULTIPLY, OP_DIVIDE, OP_INT_DIVIDE,
+			OP_MODULO, OP_POWER, OP_BIT_AND, OP_BIT_OR, OP_BIT_XOR,
+			OP_SHIFT_LEFT, OP_SHIFT_RIGHT:
+			right := vm.pop()
+			left := vm.pop()
+			vm.push(vm.binary(op, left, right, token))
+		case OP_NOT:
+			vm.push(!getTruthy(vm.pop()))
+		case OP_NEGATE:
+			n, ok := vm.pop().(scanner.Number)
+			if !ok {
+				panic(NewRuntimeError(token("-"), "operand must be a number"))
+			}
+			vm.push(-n)
+		case OP_BIT_NOT:
+			n, ok := toInteger(vm.pop())
+			if !ok {
+				panic(NewRuntimeError(token("~"), "operand must be an integer"))
+			}
+			vm.push(scanner.Number(^n))
+		case OP_INCREMENT, OP_DECREMENT:
+			n, ok := vm.pop().(scanner.Number)
+			if !ok {
+				panic(NewRuntimeError(token(""), "operand must be a number"))
+			}
+			if op == OP_INCREMENT {
+				vm.push(n + 1)
+			} else {
+				vm.push(n - 1)
+			}
+		case OP_PRINT:
+			fmt.Fprintln(stdout, stringify(vm.pop()))
+		case OP_JUMP:
+			offset := readShort()
+			frame.ip += offset
+		case OP_JUMP_IF_FALSE:
+			offset := readShort()
+			if !getTruthy(vm.peek(0)) {
+				frame.ip += offset
+			}
+		case OP_LOOP:
+			offset := readShort()
+			frame.ip -= offset
+		case OP_CALL:
+			argCount := int(readByte())
+			vm.call(vm.peek(argCount), argCount, token(")"))
+			refresh()
+		case OP_CLOSURE:
+			function := chunk.constants[readShort()].(*VMFunction)
+			closure := NewVMClosure(function)
+			for i := range closure.upvalues {
+				isLocal := readByte()
+				index := int(readByte())
+				if isLocal == 1 {
+					closure.upvalues[i] = vm.captureUpvalue(frame.base + index)
+				} else {
+					closure.upvalues[i] = frame.closure.upvalues[index]
+				}
+			}
+			vm.push(closure)
+		case OP_CLOSE_UPVALUE:
+			vm.closeUpvalues(len(vm.stack) - 1)
+			vm.pop()
+		case OP_RETURN:
+			result := vm.pop()
+			vm.closeUpvalues(frame.base)
+			vm.stack = vm.stack[:frame.base]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == 0 {
+				return result, true
+			}
+			vm.push(result)
+			refresh()
+		case OP_CLASS:
+			vm.push(NewVMClass(readString()))
+		case OP_INHERIT:
+			class := vm.pop().(*VMClass)
+			superclass, ok := vm.peek(0).(*VMClass)
+			if !ok {
+				panic(NewRuntimeError(token(""), "superclass must be a class"))
+			}
+			for name, method := range superclass.methods {
+				class.methods[name] = method
+			}
+		case OP_METHOD:
+			method := vm.pop().(*VMClosure)
+			vm.peek(0).(*VMClass).methods[readString()] = method
+		case OP_LIST:
+			count := readShort()
+			elements := make([]Val, count)
+			copy(elements, vm.stack[len(vm.stack)-count:])
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(NewLoxList(elements))
+		case OP_MAP:
+			count := readShort()
+			entries := vm.stack[len(vm.stack)-2*count:]
+			m := NewLoxMap()
+			brace := token("{")
+			for i := 0; i < count; i++ {
+				m.Set(brace, entries[2*i], entries[2*i+1])
+			}
+			vm.stack = vm.stack[:len(vm.stack)-2*count]
+			vm.push(m)
+		case OP_INDEX:
+			index := vm.pop()
+			bracket := token("[")
+			switch obj := vm.pop().(type) {
+			case *LoxList:
+				vm.push(obj.Get(bracket, index))
+			case *LoxMap:
+				vm.push(obj.Get(bracket, index))
+			default:
+				panic(NewRuntimeError(bracket, "only lists and maps can be indexed"))
+			}
+		case OP_INDEX_SET:
+			val := vm.pop()
+			index := vm.pop()
+			bracket := token("[")
+			switch obj := vm.pop().(type) {
+			case *LoxList:
+				obj.Set(bracket, index, val)
+			case *LoxMap:
+				obj.Set(bracket, index, val)
+			default:
+				panic(NewRuntimeError(bracket, "only lists and maps can be indexed"))
+			}
+			vm.push(val)
+		case OP_SLICE:
+			end := vm.pop()
+			start := vm.pop()
+			bracket := token("[")
+			list, ok := vm.pop().(*LoxList)
+			if !ok {
+				panic(NewRuntimeError(bracket, "only lists can be sliced"))
+			}
+			vm.push(list.Slice(bracket, start, end))
+		case OP_INTERPOLATE:
+			count := readShort()
+			buf := &strings.Builder{}
+			for _, part := range vm.stack[len(vm.stack)-count:] {
+				buf.WriteString(stringify(part))
+			}
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(buf.String())
+		case OP_ITERATOR:
+			vm.push(iterate(token("in"), vm.pop()))
+		case OP_FOR_NEXT:
+			offset := readShort()
+			if val, ok := vm.peek(0).(Iterator).Next(); ok {
+				vm.push(val)
+			} else {
+				frame.ip += offset
+			}
+		case OP_THROW:
+			switch val := vm.pop().(type) {
+			// thrown again after finally
+			case *RuntimeError:
+				panic(val)
+			case *Throw:
+				panic(val)
+			default:
+				panic(NewThrow(token("throw"), val))
+			}
+		case OP_TRY, OP_TRY_FINALLY:
+			offset := readShort()
+			vm.handlers = append(vm.handlers, handler{
+				frame:   len(vm.frames) - 1,
+				ip:      frame.ip + offset,
+				sp:      len(vm.stack),
+				finally: op == OP_TRY_FINALLY,
+			})
+		case OP_END_TRY:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case OP_IMPORT:
+			path := chunk.constants[readShort()]
+			importer := frame.closure.function.module
+			pathToken := &scanner.Token{Type: scanner.STRING, Literal: path, Line: chunk.line(frame.ip - 1)}
+			vm.push(importer.lox.importModule(importer, pathToken))
+		default:
+			panic(sprintf("vm: unknown opcode %v", op))
+		}
+	}
+}
+
+// unwind the stack to the innermost handler and push the error,
+// return false if it can't be handled
+func (vm *VM) handle(e interface{}) bool {
+	var val Val
+	switch err := e.(type) {
+	case *RuntimeError:
+		val = NewLoxError(err)
+	case *Throw:
+		val = err.value
+	default:
+		return false
+	}
+	if len(vm.handlers) == 0 {
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.frames = vm.frames[:h.frame+1]
+	vm.closeUpvalues(h.sp)
+	vm.stack = vm.stack[:h.sp]
+	if h.finally {
+		vm.push(e)
+	} else {
+		vm.push(val)
+	}
+	vm.frames[h.frame].ip = h.ip
+	return true
+}
+
+var binaryOperators = map[OpCode]scanner.TokenType{
+	OP_GREATER:       scanner.GREATER,
+	OP_GREATER_EQUAL: scanner.GREATER_EQUAL,
+	OP_LESS:          scanner.LESS,
+	OP_LESS_EQUAL:    scanner.LESS_EQUAL,
+	OP_ADD:           scanner.PLUS,
+	OP_SUBTRACT:      scanner.MINUS,
+	OP_MULTIPLY:      scanner.STAR,
+	OP_DIVIDE:        scanner.SLASH,
+	OP_INT_DIVIDE:    scanner.TILDE_SLASH,
+	OP_MODULO:        scanner.PERCENT,
+	OP_POWER:         scanner.STAR_STAR,
+	OP_BIT_AND:       scanner.AMPERSAND,
+	OP_BIT_OR:        scanner.PIPE,
+	OP_BIT_XOR:       scanner.CARET,
+	OP_SHIFT_LEFT:    scanner.LESS_LESS,
+	OP_SHIFT_RIGHT:   scanner.GREATER_GREATER,
+}
+
+// numbers take the fast path, everything else, including errors,
+// goes to binaryOperation shared with the interpreter
+func (vm *VM) binary(op OpCode, left, right Val, token func(string) *scanner.Token) Val {
+	l, lok := left.(scanner.Number)
+	r, rok := right.(scanner.Number)
+	if lok && rok {
+		switch op {
+		case OP_ADD:
+			return l + r
+		case OP_SUBTRACT:
+			return l - r
+		case OP_MULTIPLY:
+			return l * r
+		case OP_DIVIDE:
+			if r != 0 {
+				return l / r
+			}
+		case OP_INT_DIVIDE:
+			if r != 0 {
+				return math.Floor(l / r)
+			}
+		case OP_GREATER:
+			return l > r
+		case OP_GREATER_EQUAL:
+			return l >= r
+		case OP_LESS:
+			return l < r
+		case OP_LESS_EQUAL:
+			return l <= r
+		}
+	}
+	typ := binaryOperators[op]
+	return binaryOperation(typ, token(string(typ)), left, right)
+}
+
+/*----------  Call  ----------*/
+
+// callee and arguments are on stack top
+func (vm *VM) call(callee Val, argCount int, paren *scanner.Token) {
+	switch c := callee.(type) {
+	case *VMClosure:
+		vm.callClosure(c, argCount, paren)
+	case *VMBoundMethod:
+		vm.stack[len(vm.stack)-argCount-1] = c.receiver
+		vm.callClosure(c.method, argCount, paren)
+	case *VMClass:
+		vm.stack[len(vm.stack)-argCount-1] = NewVMInstance(c)
+		if init, ok := c.methods["init"]; ok {
+			vm.callClosure(init, argCount, paren)
+		} else if argCount != 0 {
+			panic(NewRuntimeError(paren, sprintf("expect 0 arguments but got %d", argCount)))
+		}
+	case *Function:
+		if argCount != c.arity {
+			panic(NewRuntimeError(paren, sprintf("expect %d arguments but got %d", c.arity, argCount)))
+		}
+		arguments := make([]Val, argCount)
+		copy(arguments, vm.stack[len(vm.stack)-argCount:])
+		result := c.Call(nil, paren, arguments)
+		vm.stack = vm.stack[:len(vm.stack)-argCount-1]
+		vm.push(result)
+	default:
+		panic(NewRuntimeError(paren, "can only call functions and classes"))
+	}
+}
+
+func (vm *VM) callClosure(closure *VMClosure, argCount int, paren *scanner.Token) {
+	if argCount != closure.function.arity {
+		panic(NewRuntimeError(paren, sprintf("expect %d arguments but got %d", closure.function.arity, argCount)))
+	}
+	// the first frame is top-level code
+	if len(vm.frames)-1 >= vm.maxCallDepth {
+		panic(NewRuntimeError(paren, "stack overflow"))
+	}
+	vm.frames = append(vm.frames, CallFrame{closure, 0, len(vm.stack) - argCount - 1})
+}
+
+/*----------  Upvalue  ----------*/
+
+// closures capturing the same variable share one upvalue
+func (vm *VM) captureUpvalue(slot int) *VMUpvalue {
+	for _, upvalue := range vm.openUpvalues {
+		if upvalue.slot == slot {
+			return upvalue
+		}
+	}
+	upvalue := &VMUpvalue{slot: slot, open: true}
+	vm.openUpvalues = append(vm.openUpvalues, upvalue)
+	return upvalue
+}
+
+// move variables at or above slot to heap
+func (vm *VM) closeUpvalues(slot int) {
+	open := vm.openUpvalues[:0]
+	for _, upvalue := range vm.openUpvalues {
+		if upvalue.slot >= slot {
+			upvalue.closed = vm.stack[upvalue.slot]
+			upvalue.open = false
+		} else {
+			open = append(open, upvalue)
+		}
+	}
+	vm.openUpvalues = open
+}
+
+/*----------  Helper Methods  ----------*/
+
+func (vm *VM) push(val Val) {
+	vm.stack = append(vm.stack, val)
+}
+
+func (vm *VM) pop() Val {
+	val := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return val
+}
+
+// distance from stack top
+func (vm *VM) peek(distance int) Val {
+	return vm.stack[len(vm.stack)-1-distance]
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cjting.me/lox/scanner"
+	"github.com/stretchr/testify/assert"
+)
+
+// like runLox, but run source on VM
+func runLoxVM(source string) (string, error) {
+	buf := &bytes.Buffer{}
+	prev := stdout
+	stdout = buf
+	defer func() { stdout = prev }()
+
+	lox := NewLox()
+	lox.SetEngine(EngineVM)
+	err := lox.Eval(source)
+	return buf.String(), err
+}
+
+// both engines should print the same output and report the same error
+func TestVMSameAsInterpreter(t *testing.T) {
+	programs := map[string]string{
+		"arithmetic": `
+print 1 + 2 * 3 - 4 / 2;
+print 7 % 3, -7 % 3, 7 ~/ 2, 2 ** 10;
+print (6 & 3) | 8, 5 ^ 1, ~0, 1 << 4, -16 >> 2;
+print "a" + "b", 1 < 2, 2 <= 1, 1 == 1, nil != false, !nil;
+print "sum ${1 + 2} of ${"parts"}";
+print true ? 1 : 2, nil or "or", 1 and 2;
+`,
+		"variables": `
+var a = 1;
+var b;
+print b;
+a += 2; a *= 3; a -= 1; a /= 2; a %= 3;
+print a;
+var c = 1;
+print c++, c, ++c, c--, --c;
+{
+  var a = "inner";
+  var d = 10;
+  d += 1;
+  print a, d++, d;
+}
+`,
+		"closures": `
+func makeCounter() {
+  var i = 0;
+  func count() {
+    i = i + 1;
+    return i;
+  }
+  return count;
+}
+var c1 = makeCounter();
+var c2 = makeCounter();
+print c1(), c1(), c2();
+
+var fs = [];
+for (var i = 0; i < 3; i++) {
+  var j = i;
+  push(fs, func() { return j; });
+}
+for (var f in fs) print f();
+
+func outer() {
+  var x = "x";
+  func middle() {
+    func inner() { x = x + "!"; return x; }
+    return inner;
+  }
+  return middle();
+}
+var inner = outer();
+print inner(), inner();
+print makeCounter, func() {};
+`,
+		"classes": `
+class Animal {
+  init(name) { this.name = name; }
+  speak() { return this.name + " makes a sound"; }
+  kind() { return "animal"; }
+}
+class Dog < Animal {
+  init(name) { super.init(name); this.tricks = 0; }
+  speak() { return super.speak() + ", woof"; }
+}
+var d = Dog("rex");
+print d.speak(), d.kind(), d.tricks;
+print d, Dog, d.speak;
+var speak = d.speak;
+d.name = "max";
+print speak();
+class Empty {}
+print Empty();
+class Counter {
+  init() { this.n = 0; return; }
+  inc() { this.n++; return this; }
+}
+print Counter().inc().inc().n;
+print Counter().init();
+`,
+		"containers": `
+var xs = [1, "two", [3]];
+xs[0] = 10;
+print xs, xs[-1][0], xs[1:], xs[:1], len(xs);
+push(xs, nil);
+print pop(xs), xs;
+var m = {"a": 1, 2: "b"};
+m["c"] = 3;
+print m, m["a"], keys(m), has(m, 2);
+for (var k in m) print k;
+for (var ch in "hé") print ch;
+for (var n in range(5, 0, -2)) print n;
+`,
+		"control flow": `
+var i = 0;
+while (true) {
+  i++;
+  if (i % 2 == 0) continue;
+  if (i > 7) break;
+  print i;
+}
+for (var j = 0; j < 3; j++) {
+  for (var k in [1, 2, 3]) {
+    if (k == 2) continue;
+    if (j == 1) break;
+    print j, k;
+  }
+}
+func name(n) {
+  switch (n) {
+  case 1, 2:
+    return "small";
+  case 3:
+    print "three";
+    break;
+  default:
+    return "big";
+  }
+  return "after switch";
+}
+print name(1), name(3), name(9);
+for (var x in range(0, 4, 1)) {
+  switch (x) {
+  case 1: continue;
+  case 2: break;
+  }
+  print x;
+}
+`,
+		"exceptions": `
+try {
+  throw {"code": 1};
+} catch (e) {
+  print e["code"];
+}
+try {
+  nil.x;
+} catch (e) {
+  print e.message, e.line, e;
+}
+func f() {
+  for (var i in range(0, 3, 1)) {
+    try {
+      try {
+        if (i == 0) continue;
+        if (i == 1) throw "thrown";
+        return "returned";
+      } finally {
+        print "inner ${i}";
+      }
+    } catch (e) {
+      print "caught ${e}";
+    } finally {
+      print "outer ${i}";
+    }
+  }
+}
+print f();
+func g() {
+  var captured = "before";
+  func get() { return captured; }
+  try {
+    var x = "local";
+    throw get;
+  } catch (fn) {
+    captured = "after";
+    print fn();
+  } finally {
+    print "done";
+  }
+}
+g();
+func h(n) {
+  try {
+    if (n > 0) return h(n - 1);
+    throw "deep";
+  } finally {
+    print "unwind ${n}";
+  }
+}
+try { h(2); } catch (e) { print e; }
+`,
+		"runtime error": `
+print "before";
+func f(a) { return a + 1; }
+f("s");
+print "after";
+`,
+		"uncaught throw": `
+try {
+  throw "first";
+} finally {
+  print "finally";
+}
+`,
+		"wrong arity":        "func f(a, b) {}\nf(1);",
+		"call non callable":  "var a = 1;\na();",
+		"undefined variable": "print 1;\nprint missing;",
+		"undefined property": "class A {}\nprint A().missing;",
+		"bad superclass":     "var NotClass = 1;\nclass B < NotClass {}",
+		"bad iterable":       "for (var x in 1) print x;",
+		"bad operand":        "var s = \"a\";\ns++;",
+		"stack overflow":     "func f(n) {\n  return f(n + 1);\n}\nf(0);",
+	}
+
+	for name, source := range programs {
+		t.Run(name, func(t *testing.T) {
+			expectedOut, expectedErr := runLox(source)
+			out, err := runLoxVM(source)
+			assert.Equal(t, expectedOut, out)
+			if expectedErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, expectedErr.Error())
+			}
+		})
+	}
+}
+
+func TestVMStackOverflow(t *testing.T) {
+	_, err := runLoxVM("func f() { f(); }\nf();")
+	assert.EqualError(t, err, "runtime error: line 1, stack overflow")
+}
+
+func TestVMREPLExpression(t *testing.T) {
+	lox := NewLox()
+	lox.SetEngine(EngineVM)
+	assert.Nil(t, lox.Eval("var a = 20;"))
+	val, err := lox.evalExpression("a * 2 + 2")
+	assert.Nil(t, err)
+	assert.Equal(t, 42.0, val)
+}
+
+func TestChunkDisassemble(t *testing.T) {
+	tokens, _ := scanner.Scan("var a = 1;\n{\n  var b = a + 2;\n  print b;\n}")
+	program, err := NewParser().Parse(tokens)
+	assert.Nil(t, err)
+	function, err := Compile(NewLoxModule(NewLox(), ""), program)
+	assert.Nil(t, err)
+
+	expected := []string{
+		"== <script> ==",
+		"0000    1 OP_CONSTANT         0 1",
+		"0003    | OP_DEFINE_GLOBAL    1 \"a\"",
+		"0006    3 OP_GET_GLOBAL       1 \"a\"",
+		"0009    | OP_CONSTANT         2 2",
+		"0012    | OP_ADD",
+		"0013    4 OP_GET_LOCAL        1",
+		"0015    | OP_PRINT",
+		"0016    | OP_POP",
+		"0017    | OP_NIL",
+		"0018    | OP_RETURN",
+		"",
+	}
+	assert.Equal(t, strings.Join(expected, "\n"), function.chunk.Disassemble(function.String()))
+}